@@ -0,0 +1,198 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/uber-go/zap"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("queue is full")
+	ErrQueueClosed = errors.New("queue is closed")
+)
+
+// AsyncSender 异步发送。
+// Enqueue立即返回请求的ID，过滤器和Sender由后台固定数量的worker执行
+type AsyncSender struct {
+	ctx         *Context
+	sendTimeout time.Duration
+	onResult    func(req *SMSReq, resp *SMSResp)
+
+	queue   chan asyncTask
+	wg      sync.WaitGroup
+	base    context.Context
+	cancel  context.CancelFunc
+	closing chan struct{}  // Close开始时关闭，唤醒等待放入队列的请求
+	pending sync.WaitGroup // 正在放入队列的请求，全部返回后才能关闭queue
+
+	closed bool
+	sync.RWMutex
+}
+
+type asyncTask struct {
+	id  string
+	req *SMSReq
+}
+
+// AsyncOptions AsyncSender的配置
+type AsyncOptions struct {
+	Workers     int                              // worker数量，默认1
+	QueueSize   int                              // 队列长度，队列满时Enqueue返回ErrQueueFull
+	SendTimeout time.Duration                    // 每个请求的发送超时，0表示不限制
+	OnResult    func(req *SMSReq, resp *SMSResp) // 每个请求发送完成后在worker中调用
+}
+
+// NewAsyncSender 创建AsyncSender并启动worker
+func NewAsyncSender(ctx *Context, opt AsyncOptions) *AsyncSender {
+	if ctx == nil {
+		ctx = &Context{}
+	}
+	ctx.setDefaults()
+	if opt.Workers <= 0 {
+		opt.Workers = 1
+	}
+	if opt.QueueSize < 0 {
+		opt.QueueSize = 0
+	}
+
+	as := &AsyncSender{
+		ctx:         ctx,
+		sendTimeout: opt.SendTimeout,
+		onResult:    opt.OnResult,
+		queue:       make(chan asyncTask, opt.QueueSize),
+		closing:     make(chan struct{}),
+	}
+	as.base, as.cancel = context.WithCancel(context.Background())
+	as.wg.Add(opt.Workers)
+	for i := 0; i < opt.Workers; i++ {
+		go as.work()
+	}
+	return as
+}
+
+// Enqueue 将req放入队列并返回它的ID，队列满时返回ErrQueueFull
+func (as *AsyncSender) Enqueue(req *SMSReq) (id string, err error) {
+	return as.enqueue(nil, req)
+}
+
+// EnqueueContext 和Enqueue一样，但队列满时会等待，直到有空位、c结束或者Close
+func (as *AsyncSender) EnqueueContext(c context.Context, req *SMSReq) (id string, err error) {
+	return as.enqueue(c, req)
+}
+
+func (as *AsyncSender) enqueue(c context.Context, req *SMSReq) (id string, err error) {
+	return as.enqueueWithID(c, "", req)
+}
+
+// enqueueWithID 使用指定的ID放入队列，id为空时由IDGen生成
+func (as *AsyncSender) enqueueWithID(c context.Context, id string, req *SMSReq) (string, error) {
+	// 放入队列时不持有锁，否则队列满时Close会一直等待
+	as.RLock()
+	if as.closed {
+		as.RUnlock()
+		return "", ErrQueueClosed
+	}
+	as.pending.Add(1)
+	as.RUnlock()
+	defer as.pending.Done()
+
+	if id == "" {
+		id = as.ctx.IDGen.Next()
+	}
+	task := asyncTask{
+		id:  id,
+		req: copyReq(req),
+	}
+	if c == nil {
+		select {
+		case as.queue <- task:
+		case <-as.closing:
+			return "", ErrQueueClosed
+		default:
+			return "", ErrQueueFull
+		}
+	} else {
+		select {
+		case as.queue <- task:
+		case <-as.closing:
+			return "", ErrQueueClosed
+		case <-c.Done():
+			return "", c.Err()
+		}
+	}
+	return task.id, nil
+}
+
+// Len 返回队列中等待发送的请求数
+func (as *AsyncSender) Len() int {
+	return len(as.queue)
+}
+
+// Close 停止接收新的请求，等待队列中的请求发送完成。
+// c结束时取消正在发送的请求，丢弃队列中剩余的请求(仍会调用OnResult)并返回c.Err()
+func (as *AsyncSender) Close(c context.Context) error {
+	as.Lock()
+	first := !as.closed
+	if first {
+		as.closed = true
+		close(as.closing)
+	}
+	as.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		if first {
+			// 等待正在放入队列的请求返回后再关闭queue
+			as.pending.Wait()
+			close(as.queue)
+		}
+		as.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		as.cancel()
+		return nil
+	case <-c.Done():
+		as.cancel()
+		<-done
+		return c.Err()
+	}
+}
+
+func (as *AsyncSender) work() {
+	defer as.wg.Done()
+	for task := range as.queue {
+		var resp *SMSResp
+		if err := as.base.Err(); err != nil {
+			as.ctx.Logger.Warn("drop queued sms", zap.String("id", task.id))
+			resp = &SMSResp{
+				ID:      task.id,
+				Code:    CodeTimeout,
+				Message: err.Error(),
+			}
+			completeResults(task.req, resp, "")
+		} else {
+			c, cancel := as.base, context.CancelFunc(func() {})
+			if as.sendTimeout > 0 {
+				c, cancel = context.WithTimeout(as.base, as.sendTimeout)
+			}
+			resp = sendWithID(c, as.ctx, task.req, task.id)
+			cancel()
+		}
+
+		if as.onResult != nil {
+			as.onResult(task.req, resp)
+		}
+	}
+}
+
+// copyReq 复制req，过滤器会修改req，而调用方可能会复用它
+func copyReq(req *SMSReq) *SMSReq {
+	r := *req
+	r.PhoneNumbers = append([]string(nil), req.PhoneNumbers...)
+	r.Args = append([]string(nil), req.Args...)
+	return &r
+}
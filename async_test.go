@@ -0,0 +1,158 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncSender(t *testing.T) {
+	var running, maxRunning int32
+	selector := &RandomSelector{}
+	selector.AddSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		resp.Code = CodeSuccess
+	}))
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]*SMSResp)
+	)
+	as := NewAsyncSender(&Context{Selector: selector, Logger: zap.NewJSON()}, AsyncOptions{
+		Workers:   3,
+		QueueSize: 100,
+		OnResult: func(req *SMSReq, resp *SMSResp) {
+			mu.Lock()
+			results[resp.ID] = resp
+			mu.Unlock()
+		},
+	})
+
+	req := getTestReq()
+	var ids []string
+	for i := 0; i < 20; i++ {
+		id, err := as.Enqueue(req)
+		require.NoError(t, err)
+		require.NotEmpty(t, id)
+		ids = append(ids, id)
+	}
+	// 入队时复制了请求
+	req.PhoneNumbers = nil
+
+	require.NoError(t, as.Close(context.Background()))
+	assert.Equal(t, int32(3), maxRunning)
+	require.Equal(t, 20, len(results))
+	for _, id := range ids {
+		assert.Equal(t, CodeSuccess, results[id].Code)
+		assert.Equal(t, 3, len(results[id].Results))
+	}
+
+	_, err := as.Enqueue(getTestReq())
+	assert.Equal(t, ErrQueueClosed, err)
+}
+
+func TestAsyncSender_Backpressure(t *testing.T) {
+	block := make(chan struct{})
+	selector := &RandomSelector{}
+	selector.AddSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		select {
+		case <-block:
+			resp.Code = CodeSuccess
+		case <-ctx.Done():
+		}
+	}))
+
+	var dropped int32
+	as := NewAsyncSender(&Context{Selector: selector, Logger: zap.NewJSON()}, AsyncOptions{
+		Workers:   1,
+		QueueSize: 2,
+		OnResult: func(req *SMSReq, resp *SMSResp) {
+			if resp.Code == CodeTimeout {
+				atomic.AddInt32(&dropped, 1)
+			}
+		},
+	})
+	as.ctx.Logger.SetLevel(zap.ErrorLevel)
+
+	// 一个正在发送，两个在队列中
+	_, err := as.Enqueue(getTestReq())
+	require.NoError(t, err)
+	for as.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = as.Enqueue(getTestReq())
+		require.NoError(t, err)
+	}
+	_, err = as.Enqueue(getTestReq())
+	assert.Equal(t, ErrQueueFull, err)
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = as.EnqueueContext(c, getTestReq())
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	c, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	err = as.Close(c)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(3), dropped)
+	close(block)
+}
+
+func TestAsyncSender_CloseWhileEnqueueing(t *testing.T) {
+	block := make(chan struct{})
+	selector := &RandomSelector{}
+	selector.AddSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		select {
+		case <-block:
+			resp.Code = CodeSuccess
+		case <-ctx.Done():
+		}
+	}))
+	as := NewAsyncSender(&Context{Selector: selector, Logger: zap.NewJSON()}, AsyncOptions{Workers: 1, QueueSize: 1})
+	as.ctx.Logger.SetLevel(zap.ErrorLevel)
+	defer close(block)
+
+	// 一个正在发送，一个在队列中，还有一个一直等待放入队列
+	_, err := as.Enqueue(getTestReq())
+	require.NoError(t, err)
+	for as.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = as.Enqueue(getTestReq())
+	require.NoError(t, err)
+	enqueued := make(chan error, 1)
+	go func() {
+		_, err := as.EnqueueContext(context.Background(), getTestReq())
+		enqueued <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	c, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() {
+		closed <- as.Close(c)
+	}()
+	select {
+	case err = <-closed:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't return")
+	}
+	assert.Equal(t, ErrQueueClosed, <-enqueued)
+}
@@ -0,0 +1,229 @@
+package sms
+
+import (
+	"errors"
+	"github.com/uber-go/zap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常
+	BreakerOpen                         // 熔断，不会被选中
+	BreakerHalfOpen                     // 半开，放行少量请求试探
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerSelector 带熔断的Selector。
+// 根据每个Sender填写的SMSResp统计它最近的失败率，超过阈值后熔断该Sender，
+// 熔断OpenTimeout之后进入半开状态试探发送，试探成功则恢复，失败则继续熔断
+type BreakerSelector struct {
+	Window           int           // 统计最近多少次发送的结果，需要在AddSender之前设置
+	MinRequests      int           // 窗口内至少有这么多次发送才会熔断
+	FailureThreshold float64       // 失败率达到该值时熔断
+	OpenTimeout      time.Duration // 熔断多久之后进入半开状态
+	HalfOpenTrials   int           // 半开状态下最多同时试探几次
+
+	senders map[string][]*breaker
+	sync.RWMutex
+}
+
+func NewBreakerSelector(failureThreshold float64, openTimeout time.Duration) *BreakerSelector {
+	return &BreakerSelector{
+		Window:           20,
+		MinRequests:      10,
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		HalfOpenTrials:   1,
+	}
+}
+
+func (bs *BreakerSelector) AddSender(category string, s Sender) {
+	bs.Lock()
+	if bs.senders == nil {
+		bs.senders = make(map[string][]*breaker)
+	}
+	bs.senders[category] = append(bs.senders[category], &breaker{
+		sender:  s,
+		results: make([]bool, bs.Window),
+	})
+	bs.Unlock()
+}
+
+// States 返回category下每个Sender(以SenderName为键)的熔断状态
+func (bs *BreakerSelector) States(category string) map[string]BreakerState {
+	bs.RLock()
+	breakers := bs.senders[category]
+	bs.RUnlock()
+
+	states := make(map[string]BreakerState, len(breakers))
+	for _, b := range breakers {
+		b.Lock()
+		states[SenderName(b.sender)] = b.state
+		b.Unlock()
+	}
+	return states
+}
+
+// Select 随机选择一个没有熔断的Sender，所有Sender都熔断时返回CodeNoSender
+func (bs *BreakerSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
+	bs.RLock()
+	breakers := bs.senders[req.Category]
+	bs.RUnlock()
+
+	now := time.Now()
+	for _, i := range rand.Perm(len(breakers)) {
+		if breakers[i].acquire(bs, now) {
+			sender = &breakerSender{breaker: breakers[i], sender: breakers[i].sender, selector: bs}
+			return
+		}
+	}
+	errCode = CodeNoSender
+	if len(breakers) == 0 {
+		err = errors.New("no sender under " + req.Category)
+	} else {
+		err = errors.New("all senders are open under " + req.Category)
+	}
+	return
+}
+
+type breaker struct {
+	sender Sender
+
+	state    BreakerState
+	results  []bool // 环形缓冲，true表示失败
+	pos      int
+	count    int
+	failures int
+	openedAt time.Time
+	trials   int // 半开状态下正在进行的试探次数
+	sync.Mutex
+}
+
+// acquire 判断是否可以使用该Sender发送
+func (b *breaker) acquire(bs *BreakerSelector, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < bs.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trials = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.trials >= bs.HalfOpenTrials {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+// record 记录一次发送结果，返回记录前后的状态
+func (b *breaker) record(bs *BreakerSelector, failed bool, now time.Time) (from, to BreakerState) {
+	b.Lock()
+	defer b.Unlock()
+	from = b.state
+	switch b.state {
+	case BreakerHalfOpen:
+		b.trials--
+		if failed {
+			b.state = BreakerOpen
+			b.openedAt = now
+		} else {
+			b.state = BreakerClosed
+		}
+		b.reset()
+	case BreakerClosed:
+		if len(b.results) == 0 {
+			break
+		}
+		if b.count == len(b.results) {
+			if b.results[b.pos] {
+				b.failures--
+			}
+		} else {
+			b.count++
+		}
+		b.results[b.pos] = failed
+		b.pos = (b.pos + 1) % len(b.results)
+		if failed {
+			b.failures++
+		}
+		if b.count >= bs.MinRequests && float64(b.failures)/float64(b.count) >= bs.FailureThreshold {
+			b.state = BreakerOpen
+			b.openedAt = now
+			b.reset()
+		}
+	}
+	to = b.state
+	return
+}
+
+// release 不记录结果，释放半开状态下的试探名额
+func (b *breaker) release() {
+	b.Lock()
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+	b.Unlock()
+}
+
+func (b *breaker) reset() {
+	for i := range b.results {
+		b.results[i] = false
+	}
+	b.pos, b.count, b.failures = 0, 0, 0
+}
+
+// breakerSender 发送后将结果记录到breaker中，sender是实际发送的Sender，可能带有重试
+type breakerSender struct {
+	*breaker
+	sender   Sender
+	selector *BreakerSelector
+}
+
+func (s *breakerSender) Name() string {
+	return SenderName(s.sender)
+}
+
+func (s *breakerSender) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
+	s.sender.Send(ctx, req, resp)
+
+	// 调用方取消或超时不是Sender的问题，不计入失败率；Sender自己超时计为失败
+	if ctx.Err() != nil {
+		s.release()
+		return
+	}
+	failed := resp.Code != CodeSuccess && resp.Code != CodeSuccessPart
+	from, to := s.record(s.selector, failed, time.Now())
+	if from != to {
+		ctx.Logger.Warn(
+			"sender breaker state changed",
+			zap.String("id", resp.ID),
+			zap.String("sender", s.Name()),
+			zap.Stringer("from", from),
+			zap.Stringer("to", to),
+		)
+	}
+}
@@ -0,0 +1,153 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerSelector(t *testing.T) {
+	var down int32 = 1
+	selector := NewBreakerSelector(0.5, 50*time.Millisecond)
+	selector.MinRequests = 4
+	selector.Window = 4
+	selector.AddSender("test", NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		if atomic.LoadInt32(&down) == 1 {
+			resp.Code = CodeOther
+			resp.Message = "timeout"
+			return
+		}
+		resp.Code = CodeSuccess
+	})))
+	selector.AddSender("test", NameSender("b", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	})))
+
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	ctx := &Context{Logger: logger}
+	send := func() (string, *SMSResp, error) {
+		sender, _, err := selector.Select(ctx, &SMSReq{Category: "test"})
+		if err != nil {
+			return "", nil, err
+		}
+		resp := &SMSResp{}
+		sender.Send(ctx, getTestReq(), resp)
+		return SenderName(sender), resp, nil
+	}
+
+	// a失败足够多次后被熔断，之后只会选中b
+	for i := 0; i < 100 && selector.States("test")["a"] != BreakerOpen; i++ {
+		_, _, err := send()
+		require.NoError(t, err)
+	}
+	require.Equal(t, BreakerOpen, selector.States("test")["a"])
+	for i := 0; i < 20; i++ {
+		name, _, err := send()
+		require.NoError(t, err)
+		assert.Equal(t, "b", name)
+	}
+
+	// 熔断超时后a恢复，试探成功后关闭
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 100 && selector.States("test")["a"] != BreakerClosed; i++ {
+		_, _, err := send()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, BreakerClosed, selector.States("test")["a"])
+}
+
+func TestBreakerSelector_AllOpen(t *testing.T) {
+	selector := NewBreakerSelector(0.5, time.Minute)
+	selector.MinRequests = 1
+	selector.AddSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeOther
+	}))
+
+	ctx := &Context{Logger: zap.NewJSON()}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+	sender, _, err := selector.Select(ctx, &SMSReq{Category: "test"})
+	require.NoError(t, err)
+	sender.Send(ctx, getTestReq(), &SMSResp{})
+
+	_, errCode, err := selector.Select(ctx, &SMSReq{Category: "test"})
+	assert.Error(t, err)
+	assert.Equal(t, CodeNoSender, errCode)
+}
+
+func TestBreakerSelector_Canceled(t *testing.T) {
+	selector := NewBreakerSelector(0.5, time.Minute)
+	selector.MinRequests = 1
+	selector.AddSender("test", NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeTimeout
+		resp.Message = ctx.Err().Error()
+	})))
+
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := &Context{Context: c, Logger: zap.NewJSON()}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+	for i := 0; i < 5; i++ {
+		sender, _, err := selector.Select(&Context{}, &SMSReq{Category: "test"})
+		require.NoError(t, err)
+		sender.Send(ctx, getTestReq(), &SMSResp{})
+	}
+	assert.Equal(t, BreakerClosed, selector.States("test")["a"])
+}
+
+func TestBreakerSelector_SenderTimeout(t *testing.T) {
+	selector := NewBreakerSelector(0.5, time.Minute)
+	selector.MinRequests = 1
+	selector.AddSender("test", NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeTimeout
+		resp.Message = "provider timeout"
+	})))
+
+	ctx := &Context{Context: context.Background(), Logger: zap.NewJSON()}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+	sender, _, err := selector.Select(ctx, &SMSReq{Category: "test"})
+	require.NoError(t, err)
+	sender.Send(ctx, getTestReq(), &SMSResp{})
+	assert.Equal(t, BreakerOpen, selector.States("test")["a"])
+}
+
+func TestBreakerSelector_Retry(t *testing.T) {
+	var sends int32
+	selector := NewBreakerSelector(0.5, 10*time.Millisecond)
+	selector.MinRequests = 1
+	selector.AddSender("test", NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		// 第一次发送失败使熔断打开，半开后第一次尝试失败，重试成功
+		if n := atomic.AddInt32(&sends, 1); n <= 2 {
+			resp.Code = CodeOther
+			resp.Fail = appendFailed(nil, req.PhoneNumbers, errors.New("busy"))
+			return
+		}
+		resp.Code = CodeSuccess
+	})))
+
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	ctx := &Context{
+		Selector: selector,
+		Logger:   logger,
+		Retry:    NewRetryPolicy(3, time.Millisecond, time.Millisecond),
+	}
+	ctx.Retry.MaxAttempts = 1
+	resp := Send(ctx, getTestReq())
+	require.Equal(t, CodeOther, resp.Code)
+	require.Equal(t, BreakerOpen, selector.States("test")["a"])
+
+	// 半开状态下一次请求的多次重试只占用一个试探名额，只记录最终结果
+	time.Sleep(20 * time.Millisecond)
+	ctx.Retry.MaxAttempts = 3
+	resp = Send(ctx, getTestReq())
+	assert.Equal(t, CodeSuccess, resp.Code)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&sends))
+	assert.Equal(t, BreakerClosed, selector.States("test")["a"])
+}
@@ -1,9 +1,34 @@
 package sms
 
 const (
-	CodeOther        = 0
-	CodeSuccess      = 1
-	CodeNoSender     = 2
-	CodeSuccessPart  = 3 // 成功了一部分
-	CodeInvalidParam = 4 // 不合法的参数
+	CodeOther        int32 = 0
+	CodeSuccess      int32 = 1
+	CodeNoSender     int32 = 2
+	CodeSuccessPart  int32 = 3 // 成功了一部分
+	CodeInvalidParam int32 = 4 // 不合法的参数
+	CodeTimeout      int32 = 5 // 超时或被取消
+	CodeScheduled    int32 = 6 // 已交给Scheduler，到时间后发送
+	CodeCanceled     int32 = 7 // 定时发送的请求被取消
+)
+
+// ReasonCode 号码发送失败的原因分类，客户端可以据此判断是否需要重试
+type ReasonCode string
+
+const (
+	ReasonUnknown       ReasonCode = ""
+	ReasonRateLimited   ReasonCode = "rate_limited"      // 超过发送频率限制
+	ReasonInvalidNumber ReasonCode = "invalid_number"    // 号码不合法
+	ReasonBlacklisted   ReasonCode = "blacklisted"       // 号码在黑名单中
+	ReasonProviderError ReasonCode = "provider_error"    // 短信服务商返回错误
+	ReasonTemplateError ReasonCode = "template_error"    // 模板不存在或参数不合法
+	ReasonNoSender      ReasonCode = "no_sender"         // 没有可用的Sender
+	ReasonTimeout       ReasonCode = "timeout"           // 超时或被取消
+	ReasonInternal      ReasonCode = "internal_error"    // 内部错误，比如redis不可用
+	ReasonUndelivered   ReasonCode = "undelivered"       // 短信服务商回执发送失败
+	ReasonExpired       ReasonCode = "expired"           // 短信服务商回执超过有效期
+	ReasonOptedOut      ReasonCode = "opted_out"         // 用户退订
+	ReasonNotWhitelist  ReasonCode = "not_whitelisted"   // 号码不在白名单中
+	ReasonDuplicate     ReasonCode = "duplicate"         // 重复的号码或请求
+	ReasonQuietHours    ReasonCode = "quiet_hours"       // 号码所在地处于免打扰时段
+	ReasonSensitive     ReasonCode = "sensitive_content" // 短信内容包含敏感词
 )
@@ -0,0 +1,428 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrNothingToSend = errors.New("no phone number to requeue")
+	ErrInvalidID     = errors.New("invalid dead letter id")
+)
+
+// DeadLetter 重试和所有Sender都失败之后保存下来的请求
+type DeadLetter struct {
+	ID        string   // 即Resp.ID
+	Req       *SMSReq  // 执行过滤器之前的原始请求
+	Resp      *SMSResp // 最终的结果，Resp.Attempts是发送记录
+	CreatedAt time.Time
+}
+
+// DeadLetterStore 保存DeadLetter。
+// List按CreatedAt从早到晚返回，limit不大于0时返回offset之后的全部
+type DeadLetterStore interface {
+	Put(dl *DeadLetter) error
+	Get(id string) (*DeadLetter, error)
+	List(offset, limit int) ([]*DeadLetter, error)
+	Delete(id string) error
+	Purge(before time.Time) (n int, err error) // 删除CreatedAt在before之前的
+}
+
+// deadNumbers 返回交给过Sender、重试之后仍然失败并且可以重试的号码。
+// 其他失败即使重新发送也不会成功，被过滤器拒绝的号码(比如超过频率限制)也不需要重新发送
+func deadNumbers(resp *SMSResp) []string {
+	sent := make(map[string]bool, len(resp.Results))
+	for _, r := range resp.Results {
+		if r.Sender != "" && r.Status == StatusFailed {
+			sent[r.PhoneNumber] = true
+		}
+	}
+	var pns []string
+	for _, f := range resp.Fail {
+		if f.Retryable && sent[f.PhoneNumber] {
+			pns = append(pns, f.PhoneNumber)
+			delete(sent, f.PhoneNumber)
+		}
+	}
+	return pns
+}
+
+func putDeadLetter(ctx *Context, req *SMSReq, resp *SMSResp) {
+	if len(deadNumbers(resp)) == 0 {
+		return
+	}
+	err := ctx.DeadLetter.Put(&DeadLetter{
+		ID:        resp.ID,
+		Req:       req,
+		Resp:      resp,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		ctx.Logger.Error("cann't put dead letter", zap.String("id", resp.ID), zap.Error(err))
+	}
+}
+
+// RequeueDeadLetter 重新发送id对应的请求中交给过Sender并且可以重试的失败号码，没有这样的号码时返回ErrNothingToSend。
+// 发送成功，或者再次失败并以新的ID进入ctx.DeadLetter之后，才将它从store中删除
+func RequeueDeadLetter(c context.Context, ctx *Context, store DeadLetterStore, id string) (*SMSResp, error) {
+	dl, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	req := copyReq(dl.Req)
+	req.PhoneNumbers = deadNumbers(dl.Resp)
+	if len(req.PhoneNumbers) == 0 {
+		return nil, ErrNothingToSend
+	}
+
+	// 和发送时一样先设置默认值，才能知道再次失败时进入了哪个DeadLetterStore
+	ctx.setDefaults()
+	resp := SendContext(c, ctx, req)
+	if resp.Code != CodeSuccess && resp.Code != CodeSuccessPart {
+		if ctx.DeadLetter == nil {
+			return resp, nil
+		}
+		if _, err = ctx.DeadLetter.Get(resp.ID); err != nil {
+			if err == ErrNotFound {
+				err = nil
+			}
+			return resp, err
+		}
+	}
+	return resp, store.Delete(id)
+}
+
+// MemoryDeadLetterStore 保存在内存中的DeadLetterStore
+type MemoryDeadLetterStore struct {
+	letters map[string]*DeadLetter
+	sync.RWMutex
+}
+
+func (ms *MemoryDeadLetterStore) Put(dl *DeadLetter) error {
+	ms.Lock()
+	if ms.letters == nil {
+		ms.letters = make(map[string]*DeadLetter)
+	}
+	ms.letters[dl.ID] = dl
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	ms.RLock()
+	dl, ok := ms.letters[id]
+	ms.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return dl, nil
+}
+
+func (ms *MemoryDeadLetterStore) List(offset, limit int) ([]*DeadLetter, error) {
+	ms.RLock()
+	letters := make([]*DeadLetter, 0, len(ms.letters))
+	for _, dl := range ms.letters {
+		letters = append(letters, dl)
+	}
+	ms.RUnlock()
+	sortDeadLetters(letters)
+	return pageDeadLetters(letters, offset, limit), nil
+}
+
+func (ms *MemoryDeadLetterStore) Delete(id string) error {
+	ms.Lock()
+	delete(ms.letters, id)
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryDeadLetterStore) Purge(before time.Time) (n int, err error) {
+	ms.Lock()
+	for id, dl := range ms.letters {
+		if dl.CreatedAt.Before(before) {
+			delete(ms.letters, id)
+			n++
+		}
+	}
+	ms.Unlock()
+	return
+}
+
+func sortDeadLetters(letters []*DeadLetter) {
+	sort.Slice(letters, func(i, j int) bool {
+		if letters[i].CreatedAt.Equal(letters[j].CreatedAt) {
+			return letters[i].ID < letters[j].ID
+		}
+		return letters[i].CreatedAt.Before(letters[j].CreatedAt)
+	})
+}
+
+func pageDeadLetters(letters []*DeadLetter, offset, limit int) []*DeadLetter {
+	if offset >= len(letters) {
+		return nil
+	}
+	letters = letters[offset:]
+	if limit > 0 && limit < len(letters) {
+		letters = letters[:limit]
+	}
+	return letters
+}
+
+// FileDeadLetterStore 每个DeadLetter以JSON格式保存为Dir下的一个文件
+type FileDeadLetterStore struct {
+	Dir string
+	sync.RWMutex
+}
+
+func NewFileDeadLetterStore(dir string) (*FileDeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterStore{Dir: dir}, nil
+}
+
+// path 返回id对应的文件，id为空或者包含路径分隔符、".."时返回ErrInvalidID，避免访问Dir之外的文件
+func (fs *FileDeadLetterStore) path(id string) (string, error) {
+	if id == "" || strings.Contains(id, "..") || strings.ContainsAny(id, `/\`) {
+		return "", ErrInvalidID
+	}
+	return filepath.Join(fs.Dir, id+".json"), nil
+}
+
+func (fs *FileDeadLetterStore) Put(dl *DeadLetter) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	path, err := fs.path(dl.ID)
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	// 先写临时文件再改名，避免进程退出时留下不完整的文件
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fs *FileDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	path, err := fs.path(id)
+	if err != nil {
+		return nil, err
+	}
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.read(path)
+}
+
+func (fs *FileDeadLetterStore) read(path string) (*DeadLetter, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	dl := &DeadLetter{}
+	if err = json.Unmarshal(data, dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (fs *FileDeadLetterStore) all() ([]*DeadLetter, error) {
+	infos, err := ioutil.ReadDir(fs.Dir)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		dl, err := fs.read(filepath.Join(fs.Dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, dl)
+	}
+	sortDeadLetters(letters)
+	return letters, nil
+}
+
+func (fs *FileDeadLetterStore) List(offset, limit int) ([]*DeadLetter, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	letters, err := fs.all()
+	if err != nil {
+		return nil, err
+	}
+	return pageDeadLetters(letters, offset, limit), nil
+}
+
+func (fs *FileDeadLetterStore) Delete(id string) error {
+	path, err := fs.path(id)
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (fs *FileDeadLetterStore) Purge(before time.Time) (n int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	letters, err := fs.all()
+	if err != nil {
+		return 0, err
+	}
+	for _, dl := range letters {
+		if !dl.CreatedAt.Before(before) {
+			break
+		}
+		path, err := fs.path(dl.ID)
+		if err != nil {
+			return n, err
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RedisDeadLetterStore 保存在redis中的DeadLetterStore。
+// DeadLetter以JSON格式保存在Prefix+"entries"哈希表中，Prefix+"index"有序集合按CreatedAt排序，分数是毫秒时间戳
+type RedisDeadLetterStore struct {
+	RedisPool *redis.Pool
+	Prefix    string
+}
+
+func NewRedisDeadLetterStore(redisPool *redis.Pool, prefix string) *RedisDeadLetterStore {
+	return &RedisDeadLetterStore{
+		RedisPool: redisPool,
+		Prefix:    prefix,
+	}
+}
+
+func (rs *RedisDeadLetterStore) entriesKey() string {
+	return rs.Prefix + "entries"
+}
+
+func (rs *RedisDeadLetterStore) indexKey() string {
+	return rs.Prefix + "index"
+}
+
+func (rs *RedisDeadLetterStore) Put(dl *DeadLetter) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("HSET", rs.entriesKey(), dl.ID, data)
+	c.Send("ZADD", rs.indexKey(), unixMilli(dl.CreatedAt), dl.ID)
+	_, err = c.Do("EXEC")
+	return err
+}
+
+func (rs *RedisDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	data, err := redis.Bytes(c.Do("HGET", rs.entriesKey(), id))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	dl := &DeadLetter{}
+	if err = json.Unmarshal(data, dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (rs *RedisDeadLetterStore) List(offset, limit int) ([]*DeadLetter, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	stop := -1
+	if limit > 0 {
+		stop = offset + limit - 1
+	}
+	ids, err := redis.Strings(c.Do("ZRANGE", rs.indexKey(), offset, stop))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	args := redis.Args{}.Add(rs.entriesKey()).AddFlat(ids)
+	values, err := redis.ByteSlices(c.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(values))
+	for _, data := range values {
+		if data == nil { // 索引和数据不一致，忽略
+			continue
+		}
+		dl := &DeadLetter{}
+		if err = json.Unmarshal(data, dl); err != nil {
+			return nil, err
+		}
+		letters = append(letters, dl)
+	}
+	return letters, nil
+}
+
+func (rs *RedisDeadLetterStore) Delete(id string) error {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("HDEL", rs.entriesKey(), id)
+	c.Send("ZREM", rs.indexKey(), id)
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (rs *RedisDeadLetterStore) Purge(before time.Time) (n int, err error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	max := "(" + strconv.FormatInt(unixMilli(before), 10)
+	ids, err := redis.Strings(c.Do("ZRANGEBYSCORE", rs.indexKey(), "-inf", max))
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	c.Send("MULTI")
+	c.Send("HDEL", redis.Args{}.Add(rs.entriesKey()).AddFlat(ids)...)
+	c.Send("ZREM", redis.Args{}.Add(rs.indexKey()).AddFlat(ids)...)
+	if _, err = c.Do("EXEC"); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
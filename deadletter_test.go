@@ -0,0 +1,196 @@
+package sms
+
+import (
+	"context"
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testDeadLetterStore(t *testing.T, store DeadLetterStore) {
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		err := store.Put(&DeadLetter{
+			ID:        strconv.Itoa(i),
+			Req:       getTestReq(),
+			Resp:      &SMSResp{ID: strconv.Itoa(i), Code: CodeOther},
+			CreatedAt: now.Add(time.Duration(i) * time.Second),
+		})
+		require.NoError(t, err)
+	}
+
+	dl, err := store.Get("3")
+	require.NoError(t, err)
+	assert.Equal(t, "3", dl.Resp.ID)
+	assert.Equal(t, getTestReq(), dl.Req)
+
+	_, err = store.Get("100")
+	assert.Equal(t, ErrNotFound, err)
+
+	letters, err := store.List(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(letters))
+	assert.Equal(t, "1", letters[0].ID)
+	assert.Equal(t, "2", letters[1].ID)
+
+	require.NoError(t, store.Delete("1"))
+	n, err := store.Purge(now.Add(2500 * time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	letters, err = store.List(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(letters))
+	assert.Equal(t, "3", letters[0].ID)
+	assert.Equal(t, "4", letters[1].ID)
+}
+
+func TestMemoryDeadLetterStore(t *testing.T) {
+	testDeadLetterStore(t, &MemoryDeadLetterStore{})
+}
+
+func TestFileDeadLetterStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileDeadLetterStore(dir)
+	require.NoError(t, err)
+	testDeadLetterStore(t, store)
+
+	for _, id := range []string{"", "../x", "a/b", `a\b`, ".."} {
+		assert.Equal(t, ErrInvalidID, store.Put(&DeadLetter{ID: id, Req: getTestReq()}), id)
+		_, err = store.Get(id)
+		assert.Equal(t, ErrInvalidID, err, id)
+		assert.Equal(t, ErrInvalidID, store.Delete(id), id)
+	}
+}
+
+func TestRedisDeadLetterStore(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	prefix := "deadletter:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	store := NewRedisDeadLetterStore(pool, prefix)
+	testDeadLetterStore(t, store)
+
+	// 分数是毫秒时间戳，不会因为精度损失而改变顺序
+	c := pool.Get()
+	defer c.Close()
+	at := time.Date(2017, 3, 1, 8, 0, 0, 123456789, time.UTC)
+	require.NoError(t, store.Put(&DeadLetter{ID: "ms", Req: getTestReq(), CreatedAt: at}))
+	score, err := redis.Int64(c.Do("ZSCORE", store.indexKey(), "ms"))
+	require.NoError(t, err)
+	assert.Equal(t, unixMilli(at), score)
+}
+
+func TestSend_DeadLetter(t *testing.T) {
+	req := getTestReq()
+	down := true
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		if down {
+			resp.Code = CodeOther
+			resp.Message = "down"
+			return
+		}
+		resp.Code = CodeSuccess
+	}))
+
+	store := &MemoryDeadLetterStore{}
+	ctx := &Context{
+		Selector:   selector,
+		Logger:     zap.NewJSON(),
+		DeadLetter: store,
+		Retry:      NewRetryPolicy(2, time.Millisecond, time.Millisecond),
+	}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+	resp := Send(ctx, req)
+	assert.Equal(t, CodeOther, resp.Code)
+
+	dl, err := store.Get(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, getTestReq(), dl.Req)
+	assert.Equal(t, 2, len(dl.Resp.Attempts))
+
+	// 调用方取消时没有发送，保留原来的死信
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp, err = RequeueDeadLetter(canceled, ctx, store, dl.ID)
+	require.NoError(t, err)
+	assert.Equal(t, CodeTimeout, resp.Code)
+	_, err = store.Get(dl.ID)
+	require.NoError(t, err)
+
+	// 再次失败时以新的ID进入死信，原来的删除
+	resp, err = RequeueDeadLetter(context.Background(), ctx, store, dl.ID)
+	require.NoError(t, err)
+	assert.Equal(t, CodeOther, resp.Code)
+	_, err = store.Get(dl.ID)
+	assert.Equal(t, ErrNotFound, err)
+	dl, err = store.Get(resp.ID)
+	require.NoError(t, err)
+
+	down = false
+	resp, err = RequeueDeadLetter(context.Background(), ctx, store, dl.ID)
+	require.NoError(t, err)
+	assert.Equal(t, CodeSuccess, resp.Code)
+	letters, err := store.List(0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestSend_DeadLetterRateLimited(t *testing.T) {
+	req := getTestReq()
+	var received []string
+	p := NewPipeline()
+	p.Logger = zap.NewJSON()
+	p.Logger.SetLevel(zap.ErrorLevel)
+	p.DeadLetter = &MemoryDeadLetterStore{}
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		received = append(received, req.PhoneNumbers...)
+		resp.Code = CodeOther
+		resp.Message = "down"
+	}))
+	p.Selector = selector
+	// 1000000总是超过频率限制
+	p.RegisterFilter(req.Category, func(ctx *Context, req *SMSReq, resp *SMSResp) bool {
+		pns := req.PhoneNumbers[:0:0]
+		for _, pn := range req.PhoneNumbers {
+			if pn == "1000000" {
+				resp.Fail = append(resp.Fail, NewFailReq(pn, ErrExceedLimit))
+			} else {
+				pns = append(pns, pn)
+			}
+		}
+		req.PhoneNumbers = pns
+		return len(pns) == 0
+	})
+
+	// 全部号码超过频率限制时不会进入死信
+	resp := p.Send(&SMSReq{Category: req.Category, PhoneNumbers: []string{"1000000"}})
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, ReasonRateLimited, resp.Fail[0].Reason)
+	_, err := p.DeadLetter.Get(resp.ID)
+	assert.Equal(t, ErrNotFound, err)
+	// 即使手动放入死信也没有需要重新发送的号码
+	require.NoError(t, p.DeadLetter.Put(&DeadLetter{ID: resp.ID, Req: req, Resp: resp}))
+	_, err = RequeueDeadLetter(context.Background(), &Context{Pipeline: p}, p.DeadLetter, resp.ID)
+	assert.Equal(t, ErrNothingToSend, err)
+	require.NoError(t, p.DeadLetter.Delete(resp.ID))
+
+	// 部分号码超过频率限制时只重新发送交给过Sender的号码
+	resp = p.Send(&SMSReq{Category: req.Category, PhoneNumbers: []string{"1000000", "1000001"}})
+	assert.Equal(t, 2, len(resp.Fail))
+	received = nil
+	_, err = RequeueDeadLetter(context.Background(), &Context{Pipeline: p}, p.DeadLetter, resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1000001"}, received)
+}
@@ -0,0 +1,200 @@
+package sms
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrDuplicate = errors.New("duplicate")
+
+// DedupStore 记录在窗口期内出现过的键。
+// Claim对每个键返回是否是窗口期内第一次出现，第一次出现的键会被记录window时长
+type DedupStore interface {
+	Claim(c context.Context, keys []string, window time.Duration) ([]bool, error)
+}
+
+// DedupFilter 去掉请求中重复的号码，以及Window内已经发送过相同模板和参数的号码。
+// 号码在通过过滤器时就被记录，之后发送失败的号码在Window内重新发送也会被去掉，所以Window通常只设置几秒到几分钟，
+// 用于避免客户端超时重试造成的重复发送。Store为空时只去掉请求中重复的号码
+type DedupFilter struct {
+	Store   DedupStore
+	Window  time.Duration
+	KeyFunc func(req *SMSReq, i int) string // 为空时使用DedupKey
+}
+
+// DedupKey 返回号码、req.TemplateID和req.Args的摘要
+func DedupKey(req *SMSReq, i int) string {
+	h := sha1.New()
+	io.WriteString(h, req.PhoneNumbers[i])
+	io.WriteString(h, "\x00")
+	io.WriteString(h, req.TemplateID)
+	for _, arg := range req.Args {
+		io.WriteString(h, "\x00")
+		io.WriteString(h, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (df *DedupFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, failed := df.Filter(ctx, req)
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (df *DedupFilter) Filter(ctx *Context, req *SMSReq) ([]string, []FailReq) {
+	var (
+		unique = make([]string, 0, len(req.PhoneNumbers))
+		failed []FailReq
+		seen   = make(map[string]bool, len(req.PhoneNumbers))
+	)
+	for _, pn := range req.PhoneNumbers {
+		if seen[pn] {
+			failed = append(failed, NewFailReq(pn, ErrDuplicate))
+			continue
+		}
+		seen[pn] = true
+		unique = append(unique, pn)
+	}
+	if df.Store == nil || len(unique) == 0 {
+		return unique, failed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, appendFailed(failed, unique, err)
+	}
+
+	keyFunc := df.KeyFunc
+	if keyFunc == nil {
+		keyFunc = DedupKey
+	}
+	uniqueReq := *req
+	uniqueReq.PhoneNumbers = unique
+	keys := make([]string, len(unique))
+	for i := range unique {
+		keys[i] = keyFunc(&uniqueReq, i)
+	}
+	claimed, err := df.Store.Claim(ctx, keys, df.Window)
+	if err != nil {
+		return nil, appendFailed(failed, unique, err)
+	}
+
+	newNumbers := make([]string, 0, len(unique))
+	for i, pn := range unique {
+		if claimed[i] {
+			newNumbers = append(newNumbers, pn)
+		} else {
+			failed = append(failed, NewFailReq(pn, ErrDuplicate))
+		}
+	}
+	return newNumbers, failed
+}
+
+// MemoryDedupStore 保存在内存中的DedupStore，最多保存Capacity个键，超过时淘汰最久没有出现的键。
+// 每次Claim都会从最久没有出现的键开始删除已经过期的键。Capacity为0时不限制，零值可以直接使用
+type MemoryDedupStore struct {
+	Capacity int
+
+	keys map[string]*list.Element
+	lru  *list.List
+	sync.Mutex
+}
+
+type dedupEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		Capacity: capacity,
+	}
+}
+
+func (ms *MemoryDedupStore) Claim(c context.Context, keys []string, window time.Duration) ([]bool, error) {
+	now := time.Now()
+	claimed := make([]bool, len(keys))
+	ms.Lock()
+	if ms.keys == nil {
+		ms.keys = make(map[string]*list.Element)
+		ms.lru = list.New()
+	}
+	for oldest := ms.lru.Back(); oldest != nil; oldest = ms.lru.Back() {
+		entry := oldest.Value.(*dedupEntry)
+		if now.Before(entry.expireAt) {
+			break
+		}
+		ms.lru.Remove(oldest)
+		delete(ms.keys, entry.key)
+	}
+	for i, key := range keys {
+		if e, ok := ms.keys[key]; ok {
+			entry := e.Value.(*dedupEntry)
+			ms.lru.MoveToFront(e)
+			if now.Before(entry.expireAt) {
+				continue
+			}
+			entry.expireAt = now.Add(window)
+			claimed[i] = true
+			continue
+		}
+		ms.keys[key] = ms.lru.PushFront(&dedupEntry{key: key, expireAt: now.Add(window)})
+		claimed[i] = true
+		for ms.Capacity > 0 && ms.lru.Len() > ms.Capacity {
+			oldest := ms.lru.Back()
+			ms.lru.Remove(oldest)
+			delete(ms.keys, oldest.Value.(*dedupEntry).key)
+		}
+	}
+	ms.Unlock()
+	return claimed, nil
+}
+
+// RedisDedupStore 保存在redis中的DedupStore，每个键以SET NX PX保存在Prefix+键中
+type RedisDedupStore struct {
+	RedisPool *redis.Pool
+	Prefix    string
+}
+
+func NewRedisDedupStore(redisPool *redis.Pool, prefix string) *RedisDedupStore {
+	return &RedisDedupStore{
+		RedisPool: redisPool,
+		Prefix:    prefix,
+	}
+}
+
+func (rs *RedisDedupStore) Claim(ctx context.Context, keys []string, window time.Duration) ([]bool, error) {
+	c, err := rs.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	ms := int64(window / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	for _, key := range keys {
+		c.Send("SET", rs.Prefix+key, 1, "NX", "PX", ms)
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	claimed := make([]bool, len(keys))
+	for i := range keys {
+		reply, err := redisReceive(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		claimed[i] = reply != nil
+	}
+	return claimed, nil
+}
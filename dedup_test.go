@@ -0,0 +1,105 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testDedupFilter(t *testing.T, store DedupStore) {
+	df := &DedupFilter{Store: store, Window: 100 * time.Millisecond}
+	ctx := &Context{}
+
+	req := getTestReq()
+	req.PhoneNumbers = append(req.PhoneNumbers, "1000000")
+	pns, failed := df.Filter(ctx, req)
+	assert.Equal(t, getTestReq().PhoneNumbers, pns)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, "1000000", failed[0].PhoneNumber)
+	assert.Equal(t, ReasonDuplicate, failed[0].Reason)
+
+	req = getTestReq()
+	req.PhoneNumbers = append(req.PhoneNumbers, "1000003")
+	pns, failed = df.Filter(ctx, req)
+	assert.Equal(t, []string{"1000003"}, pns)
+	assert.Equal(t, 3, len(failed))
+
+	// 参数不同不算重复
+	req = getTestReq()
+	req.Args = []string{"other"}
+	pns, _ = df.Filter(ctx, req)
+	assert.Equal(t, 3, len(pns))
+
+	time.Sleep(150 * time.Millisecond)
+	pns, failed = df.Filter(ctx, getTestReq())
+	assert.Equal(t, 3, len(pns))
+	assert.Empty(t, failed)
+}
+
+func TestDedupFilter_Memory(t *testing.T) {
+	testDedupFilter(t, NewMemoryDedupStore(100))
+	testDedupFilter(t, &MemoryDedupStore{})
+}
+
+func TestDedupFilter_Redis(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	prefix := "dedup:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	testDedupFilter(t, NewRedisDedupStore(pool, prefix))
+}
+
+func TestMemoryDedupStore_Evict(t *testing.T) {
+	store := NewMemoryDedupStore(2)
+	claimed, err := store.Claim(context.Background(), []string{"a", "b", "a", "c"}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false, true}, claimed)
+
+	// b最久没有出现，已经被淘汰
+	claimed, _ = store.Claim(context.Background(), []string{"b", "a"}, time.Minute)
+	assert.Equal(t, []bool{true, true}, claimed)
+	assert.Equal(t, 2, store.lru.Len())
+}
+
+func TestMemoryDedupStore_Expire(t *testing.T) {
+	store := &MemoryDedupStore{}
+	claimed, err := store.Claim(context.Background(), []string{"a", "b"}, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, claimed)
+
+	// 不限制数量时过期的键也会被删除
+	time.Sleep(20 * time.Millisecond)
+	claimed, _ = store.Claim(context.Background(), []string{"c"}, time.Minute)
+	assert.Equal(t, []bool{true}, claimed)
+	assert.Equal(t, 1, store.lru.Len())
+	assert.Equal(t, 1, len(store.keys))
+}
+
+func TestSend_DuplicateNumber(t *testing.T) {
+	p := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	}))
+	df := &DedupFilter{}
+	p.RegisterFilter("test", df.FilterFunc())
+	p.Messages = &MemoryMessageStore{}
+
+	req := getTestReq()
+	req.PhoneNumbers = append(req.PhoneNumbers, "1000001")
+	resp := p.Send(req)
+	assert.Equal(t, CodeSuccess, resp.Code)
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, ReasonDuplicate, resp.Fail[0].Reason)
+	require.Equal(t, 3, len(resp.Results))
+	for _, r := range resp.Results {
+		assert.Equal(t, StatusSent, r.Status, r.PhoneNumber)
+	}
+
+	m, err := p.Query(resp.ID)
+	require.NoError(t, err)
+	for _, r := range m.Recipients {
+		assert.Equal(t, ReasonUnknown, r.Reason, r.PhoneNumber)
+	}
+}
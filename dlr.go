@@ -0,0 +1,224 @@
+package sms
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/uber-go/zap"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrInvalidReport = errors.New("invalid delivery report")
+
+// maxPushBodySize 回执和上行短信推送请求体的最大长度，超过时解析失败
+const maxPushBodySize = 1 << 20
+
+// DeliveryReport 短信服务商推送的状态回执
+type DeliveryReport struct {
+	Sender      string // 发送该短信的Sender名字，见SenderName
+	MsgID       string // 短信服务商返回的消息ID
+	PhoneNumber string // 为空时更新MsgID对应的所有号码
+	Status      int32  // StatusDelivered、StatusUndelivered或StatusExpired
+	Error       string // 短信服务商返回的错误描述
+	Time        time.Time
+}
+
+// DeliverySubscriber 号码的状态因回执更新后被调用，m是更新后的消息，r是其中被更新的号码
+type DeliverySubscriber interface {
+	OnDelivery(m *Message, r *Recipient)
+}
+
+type DeliverySubscriberFunc func(m *Message, r *Recipient)
+
+func (f DeliverySubscriberFunc) OnDelivery(m *Message, r *Recipient) {
+	f(m, r)
+}
+
+// MessageSubscriber 可以由DeliverySubscriber实现，一条回执更新了多个号码时只调用一次OnMessage，不再逐个号码调用OnDelivery
+type MessageSubscriber interface {
+	OnMessage(m *Message)
+}
+
+// RequestVerifier 校验推送请求的来源，比如检查签名、token或来源IP，返回错误时拒绝请求。
+// 需要读取请求体时应该把r.Body换成可以重新读取的内容
+type RequestVerifier func(r *http.Request) error
+
+// TokenVerifier 要求请求头header的值等于token
+func TokenVerifier(header, token string) RequestVerifier {
+	return func(r *http.Request) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(token)) != 1 {
+			return errors.New("invalid " + header)
+		}
+		return nil
+	}
+}
+
+// DeliveryReports 根据回执更新MessageStore中号码的状态，并通知订阅者
+type DeliveryReports struct {
+	Messages MessageStore
+	Logger   zap.Logger
+	Verify   RequestVerifier // Handler收到请求时先校验，为空时不校验，此时Handler需要放在有认证的入口之后
+
+	subscribers []DeliverySubscriber
+	sync.RWMutex
+}
+
+func NewDeliveryReports(store MessageStore, logger zap.Logger) *DeliveryReports {
+	if logger == nil {
+		logger = zap.NewJSON()
+	}
+	return &DeliveryReports{
+		Messages: store,
+		Logger:   logger,
+	}
+}
+
+func (dr *DeliveryReports) Subscribe(s DeliverySubscriber) {
+	dr.Lock()
+	dr.subscribers = append(dr.subscribers, s)
+	dr.Unlock()
+}
+
+// Report 处理一个回执。MsgID没有对应的消息或号码时返回ErrNotFound
+func (dr *DeliveryReports) Report(report *DeliveryReport) error {
+	var (
+		code   = CodeSuccess
+		reason ReasonCode
+	)
+	switch report.Status {
+	case StatusDelivered:
+	case StatusUndelivered:
+		code, reason = CodeOther, ReasonUndelivered
+	case StatusExpired:
+		code, reason = CodeOther, ReasonExpired
+	default:
+		return ErrInvalidReport
+	}
+	if report.MsgID == "" {
+		return ErrInvalidReport
+	}
+
+	id, err := dr.Messages.Lookup(report.Sender, report.MsgID)
+	if err != nil {
+		return err
+	}
+
+	t := report.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	var (
+		updated *Message
+		indexes []int
+	)
+	err = dr.Messages.Update(id, func(m *Message) error {
+		indexes = indexes[:0]
+		for i := range m.Recipients {
+			r := &m.Recipients[i]
+			if r.MsgID != report.MsgID || r.Sender != report.Sender {
+				continue
+			}
+			if report.PhoneNumber != "" && r.PhoneNumber != report.PhoneNumber {
+				continue
+			}
+			r.Status = report.Status
+			r.Code = code
+			r.Reason = reason
+			r.Detail = report.Error
+			r.UpdatedAt = t
+			indexes = append(indexes, i)
+		}
+		if len(indexes) == 0 {
+			return ErrNotFound
+		}
+		m.UpdatedAt = time.Now()
+		m.deriveCode()
+		updated = m
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dr.RLock()
+	subscribers := dr.subscribers
+	dr.RUnlock()
+	for _, s := range subscribers {
+		if ms, ok := s.(MessageSubscriber); ok {
+			ms.OnMessage(copyMessage(updated))
+			continue
+		}
+		for _, i := range indexes {
+			m := copyMessage(updated)
+			s.OnDelivery(m, &m.Recipients[i])
+		}
+	}
+	return nil
+}
+
+// DLRParser 从短信服务商的推送请求中解析回执
+type DLRParser func(r *http.Request) ([]*DeliveryReport, error)
+
+// JSONDLRParser 解析JSON格式的回执，请求体可以是一个DeliveryReport对象或数组
+func JSONDLRParser(r *http.Request) ([]*DeliveryReport, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*DeliveryReport
+	if err = json.Unmarshal(data, &reports); err == nil {
+		return reports, nil
+	}
+	report := &DeliveryReport{}
+	if err = json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return []*DeliveryReport{report}, nil
+}
+
+// Handler 返回接收回执的http.Handler，parser为空时使用JSONDLRParser，回执的Sender总是设置为sender，不使用推送的内容。
+// Verify校验失败时返回401，无法解析时返回400，保存失败时返回500让短信服务商重新推送，没有对应消息的回执只记录日志
+func (dr *DeliveryReports) Handler(sender string, parser DLRParser) http.Handler {
+	if parser == nil {
+		parser = JSONDLRParser
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxPushBodySize)
+		if dr.Verify != nil {
+			if err := dr.Verify(r); err != nil {
+				dr.Logger.Warn("reject delivery report", zap.String("sender", sender), zap.Error(err))
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		reports, err := parser(r)
+		if err != nil {
+			dr.Logger.Warn("cann't parse delivery report", zap.String("sender", sender), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, report := range reports {
+			// 回执只能更新这个入口对应的Sender发送的消息
+			report.Sender = sender
+			err = dr.Report(report)
+			if err == ErrNotFound || err == ErrInvalidReport {
+				dr.Logger.Warn(
+					"ignore delivery report",
+					zap.String("sender", report.Sender),
+					zap.String("msgID", report.MsgID),
+					zap.Error(err),
+				)
+				continue
+			}
+			if err != nil {
+				dr.Logger.Error("cann't update delivery status", zap.String("msgID", report.MsgID), zap.Error(err))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+}
@@ -0,0 +1,138 @@
+package sms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestDeliveryReports(t *testing.T) (*DeliveryReports, string) {
+	store := &MemoryMessageStore{}
+	p := newTestPipeline(NameSender("dlr", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		for _, pn := range req.PhoneNumbers {
+			resp.Results = append(resp.Results, Result{
+				PhoneNumber: pn,
+				Status:      StatusSent,
+				MsgID:       "m-" + pn,
+			})
+		}
+		resp.Code = CodeSuccess
+	})))
+	p.Logger.SetLevel(zap.ErrorLevel)
+	p.Messages = store
+	resp := p.Send(getTestReq())
+	require.Equal(t, CodeSuccess, resp.Code)
+
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	return NewDeliveryReports(store, logger), resp.ID
+}
+
+func TestDeliveryReports_Report(t *testing.T) {
+	dr, id := newTestDeliveryReports(t)
+	var notified []Recipient
+	dr.Subscribe(DeliverySubscriberFunc(func(m *Message, r *Recipient) {
+		assert.Equal(t, id, m.ID)
+		notified = append(notified, *r)
+	}))
+
+	err := dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000000", Status: StatusDelivered})
+	require.NoError(t, err)
+	err = dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000001", Status: StatusUndelivered, Error: "blocked"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrNotFound, dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "unknown", Status: StatusDelivered}))
+	assert.Equal(t, ErrNotFound, dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000002", PhoneNumber: "1", Status: StatusDelivered}))
+	assert.Equal(t, ErrInvalidReport, dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000002", Status: StatusSent}))
+
+	require.Equal(t, 2, len(notified))
+	assert.Equal(t, "1000000", notified[0].PhoneNumber)
+	assert.Equal(t, StatusDelivered, notified[0].Status)
+	assert.Equal(t, StatusUndelivered, notified[1].Status)
+	assert.Equal(t, ReasonUndelivered, notified[1].Reason)
+	assert.Equal(t, "blocked", notified[1].Detail)
+
+	m, err := dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDelivered, m.Recipients[0].Status)
+	assert.Equal(t, StatusUndelivered, m.Recipients[1].Status)
+	assert.Equal(t, StatusSent, m.Recipients[2].Status)
+	assert.Equal(t, CodeSuccessPart, m.Code)
+
+	// 全部号码发送失败
+	err = dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000000", Status: StatusExpired})
+	require.NoError(t, err)
+	err = dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-1000002", Status: StatusUndelivered})
+	require.NoError(t, err)
+	m, err = dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, CodeOther, m.Code)
+}
+
+func TestDeliveryReports_Handler(t *testing.T) {
+	dr, id := newTestDeliveryReports(t)
+	h := dr.Handler("dlr", nil)
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/dlr", strings.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, post(`[{"msgID":"m-1000000","status":5},{"msgID":"unknown","status":5}]`))
+	assert.Equal(t, http.StatusOK, post(`{"msgID":"m-1000001","phoneNumber":"1000001","status":7}`))
+	assert.Equal(t, http.StatusBadRequest, post(`not json`))
+
+	m, err := dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDelivered, m.Recipients[0].Status)
+	assert.Equal(t, StatusExpired, m.Recipients[1].Status)
+	assert.Equal(t, ReasonExpired, m.Recipients[1].Reason)
+}
+
+func TestDeliveryReports_HandlerSender(t *testing.T) {
+	dr, id := newTestDeliveryReports(t)
+	post := func(h http.Handler, body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/dlr", strings.NewReader(body)))
+		return w.Code
+	}
+
+	// 其他Sender的入口不能通过推送的内容更新dlr发送的消息
+	assert.Equal(t, http.StatusOK, post(dr.Handler("other", nil), `{"sender":"dlr","msgID":"m-1000000","status":5}`))
+	m, err := dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSent, m.Recipients[0].Status)
+
+	big := `{"msgID":"m-1000000","status":5,"error":"` + strings.Repeat("x", maxPushBodySize) + `"}`
+	assert.Equal(t, http.StatusBadRequest, post(dr.Handler("dlr", nil), big))
+	m, err = dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSent, m.Recipients[0].Status)
+}
+
+func TestDeliveryReports_HandlerVerify(t *testing.T) {
+	dr, id := newTestDeliveryReports(t)
+	dr.Verify = TokenVerifier("X-Token", "secret")
+	h := dr.Handler("dlr", nil)
+
+	post := func(token string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/dlr", strings.NewReader(`{"msgID":"m-1000000","status":6}`))
+		r.Header.Set("X-Token", token)
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, post("wrong"))
+	m, err := dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSent, m.Recipients[0].Status)
+
+	assert.Equal(t, http.StatusOK, post("secret"))
+	m, err = dr.Messages.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusUndelivered, m.Recipients[0].Status)
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/garyburd/redigo/redis"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -14,9 +13,6 @@ const (
 	FilterGlobal = "_GLOBAL"
 )
 
-var filters = make(map[string][]Filter)
-var filtersRWM sync.RWMutex
-
 var (
 	ErrExceedLimit = errors.New("exceed limit")
 	ErrTryAgain    = errors.New("try again")
@@ -27,26 +23,14 @@ var (
 type Filter func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool)
 
 func RegisterFilter(category string, f Filter) {
-	if f == nil {
-		return
-	}
-	filtersRWM.Lock()
-	if fs, ok := filters[category]; ok {
-		fs = append(fs, f)
-		filters[category] = fs
-	} else {
-		filters[category] = []Filter{f}
-	}
-	filtersRWM.Unlock()
+	DefaultPipeline.RegisterFilter(category, f)
 }
 
 func ResetFilters(category string, newFilters []Filter) {
-	filtersRWM.Lock()
-	filters[category] = newFilters
-	filtersRWM.Unlock()
+	DefaultPipeline.ResetFilters(category, newFilters)
 }
 
-// RateLimitFilterRedis 基于redis对手机号做发送限制
+// RateLimitFilterRedis 基于redis对手机号做发送限制，RedisPool为空时不限制，不使用redis时见RateLimitFilterMemory
 type RateLimitFilterRedis struct {
 	RedisPool    *redis.Pool
 	MaxTokens    int64
@@ -54,7 +38,7 @@ type RateLimitFilterRedis struct {
 	PerSec       int64
 	MaxTryTimes  int
 	KeyExpireSec int                             // 键过期秒数
-	KeyFunc      func(req *SMSReq, i int) string // 键生成函数
+	KeyFunc      func(req *SMSReq, i int) string // 键生成函数，为空时使用号码，见NormalizeFilter和E164KeyFunc
 }
 
 func NewRateLimitFilterRedis(redisPool *redis.Pool, maxTokens, tokens int64, per time.Duration) *RateLimitFilterRedis {
@@ -81,7 +65,10 @@ func (rl *RateLimitFilterRedis) Filter(ctx *Context, req *SMSReq) ([]string, []F
 	if rl.RedisPool == nil {
 		return req.PhoneNumbers, nil
 	}
-	c := rl.RedisPool.Get()
+	c, err := rl.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
 	defer c.Close()
 
 	var (
@@ -91,22 +78,28 @@ func (rl *RateLimitFilterRedis) Filter(ctx *Context, req *SMSReq) ([]string, []F
 
 LOOP:
 	for i := 0; i < len(req.PhoneNumbers); i++ {
+		if err := ctx.Err(); err != nil {
+			failed = appendFailed(failed, req.PhoneNumbers[i:], err)
+			break
+		}
 		key := req.PhoneNumbers[i]
 		if rl.KeyFunc != nil {
 			key = rl.KeyFunc(req, i)
 		}
 		var err error
 		for j := 0; j < rl.MaxTryTimes; j++ {
+			if err = ctx.Err(); err != nil {
+				break
+			}
 			err = rl.checkLimit(ctx, key, c)
 			if err == nil {
 				newNumbers = append(newNumbers, req.PhoneNumbers[i])
 				continue LOOP
 			}
 			if err == ErrExceedLimit {
-				failed = append(failed, FailReq{
-					PhoneNumber: req.PhoneNumbers[i],
-					FailReason:  err.Error(),
-				})
+				f := NewFailReq(req.PhoneNumbers[i], err)
+				f.RetryAfter = rl.retryAfter()
+				failed = append(failed, f)
 				continue LOOP
 			}
 			if err == ErrTryAgain {
@@ -115,23 +108,30 @@ LOOP:
 		}
 		// 超过最大次数还是有错误
 		if err != nil {
-			failed = append(failed, FailReq{
-				PhoneNumber: req.PhoneNumbers[i],
-				FailReason:  err.Error(),
-			})
+			failed = append(failed, NewFailReq(req.PhoneNumbers[i], err))
 		} else {
 			failed = append(failed, FailReq{
 				PhoneNumber: req.PhoneNumbers[i],
 				FailReason:  "cann't acquire access,max try times:" + strconv.Itoa(rl.MaxTryTimes),
+				Reason:      ReasonRateLimited,
+				Retryable:   true,
 			})
 		}
 	}
 	return newNumbers, failed
 }
 
+// retryAfter 返回放入一个令牌需要的时间
+func (rl *RateLimitFilterRedis) retryAfter() time.Duration {
+	if rl.Tokens <= 0 {
+		return 0
+	}
+	return time.Duration(rl.PerSec) * time.Second / time.Duration(rl.Tokens)
+}
+
 // 这种实现在高并发下也可以做到准确限速，缺点是执行的redis命令多，性能略低
 func (rl *RateLimitFilterRedis) checkLimit(ctx *Context, key string, c redis.Conn) error {
-	reply, err := redis.String(c.Do("WATCH", key))
+	reply, err := redis.String(redisDo(ctx, c, "WATCH", key))
 	if err != nil {
 		return err
 	}
@@ -139,7 +139,7 @@ func (rl *RateLimitFilterRedis) checkLimit(ctx *Context, key string, c redis.Con
 		return errors.New("exec WATCH reply not OK")
 	}
 
-	reply, err = redis.String(c.Do("GET", key))
+	reply, err = redis.String(redisDo(ctx, c, "GET", key))
 	if err != nil && err != redis.ErrNil {
 		return err
 	} else {
@@ -182,7 +182,7 @@ func (rl *RateLimitFilterRedis) checkLimit(ctx *Context, key string, c redis.Con
 	}
 	tokens--
 
-	reply, err = redis.String(c.Do("MULTI"))
+	reply, err = redis.String(redisDo(ctx, c, "MULTI"))
 	if err != nil {
 		return err
 	}
@@ -190,9 +190,9 @@ func (rl *RateLimitFilterRedis) checkLimit(ctx *Context, key string, c redis.Con
 		return errors.New("exec MULTI reply not OK")
 	}
 
-	c.Do("SET", key, fmt.Sprintf("%d,%d", lastSec, tokens))
-	c.Do("EXPIRE", key, rl.KeyExpireSec)
-	replyIntf, err := c.Do("EXEC")
+	redisDo(ctx, c, "SET", key, fmt.Sprintf("%d,%d", lastSec, tokens))
+	redisDo(ctx, c, "EXPIRE", key, rl.KeyExpireSec)
+	replyIntf, err := redisDo(ctx, c, "EXEC")
 
 	if err != nil {
 		if err == redis.ErrNil {
@@ -236,7 +236,10 @@ func (c *RateLimitFilterRedisCounter) Filter(ctx *Context, req *SMSReq) ([]strin
 	if c.RedisPool == nil {
 		return req.PhoneNumbers, nil
 	}
-	conn := c.RedisPool.Get()
+	conn, err := c.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
 	defer conn.Close()
 
 	var (
@@ -245,16 +248,21 @@ func (c *RateLimitFilterRedisCounter) Filter(ctx *Context, req *SMSReq) ([]strin
 	)
 
 	for i := 0; i < len(req.PhoneNumbers); i++ {
+		if err := ctx.Err(); err != nil {
+			failed = appendFailed(failed, req.PhoneNumbers[i:], err)
+			break
+		}
 		key := req.PhoneNumbers[i]
 		if c.KeyFunc != nil {
 			key = c.KeyFunc(req, i)
 		}
 		err := c.checkLimit(ctx, conn, key)
 		if err != nil {
-			failed = append(failed, FailReq{
-				PhoneNumber: req.PhoneNumbers[i],
-				FailReason:  err.Error(),
-			})
+			f := NewFailReq(req.PhoneNumbers[i], err)
+			if err == ErrExceedLimit {
+				f.RetryAfter = time.Duration(c.KeyExpireSec) * time.Second
+			}
+			failed = append(failed, f)
 		} else {
 			newNumbers = append(newNumbers, req.PhoneNumbers[i])
 		}
@@ -264,25 +272,25 @@ func (c *RateLimitFilterRedisCounter) Filter(ctx *Context, req *SMSReq) ([]strin
 
 // 这种实现在高并发下不能准确的限速，性能比RateLimitFilterRedis要好大约一倍
 func (c *RateLimitFilterRedisCounter) checkLimit(ctx *Context, conn redis.Conn, key string) error {
-	count, err := redis.Int(conn.Do("GET", key))
+	count, err := redis.Int(redisDo(ctx, conn, "GET", key))
 
 	if err == nil && count >= c.Count {
 		return ErrExceedLimit
 	}
 
 	if err == redis.ErrNil { // 没有这个key
-		_, err = conn.Do("MULTI")
+		_, err = redisDo(ctx, conn, "MULTI")
 		if err != nil {
 			return err
 		}
-		conn.Do("INCR", key)
-		conn.Do("EXPIRE", key, c.KeyExpireSec)
-		_, err = conn.Do("EXEC")
+		redisDo(ctx, conn, "INCR", key)
+		redisDo(ctx, conn, "EXPIRE", key, c.KeyExpireSec)
+		_, err = redisDo(ctx, conn, "EXEC")
 		if err != nil {
 			return err
 		}
 	} else {
-		_, err = conn.Do("INCR", key)
+		_, err = redisDo(ctx, conn, "INCR", key)
 		if err != nil {
 			return err
 		}
@@ -290,23 +298,52 @@ func (c *RateLimitFilterRedisCounter) checkLimit(ctx *Context, conn redis.Conn,
 	return nil
 }
 
-type ContentFilter struct{}
+// appendFailed 将phoneNumbers都以err为原因加入failed
+func appendFailed(failed []FailReq, phoneNumbers []string, err error) []FailReq {
+	for _, pn := range phoneNumbers {
+		failed = append(failed, NewFailReq(pn, err))
+	}
+	return failed
+}
+
+type ContentFilter struct {
+	Pipeline *Pipeline // 从这里查找模板，为空时使用发送时所在的Pipeline
+}
 
 func (cf *ContentFilter) FilterFunc() Filter {
 	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
-		content, err := cf.Filter(req.TemplateID, req.Args)
+		p := cf.Pipeline
+		if p == nil {
+			p = ctx.Pipeline
+		}
+		content, err := cf.content(p, req.TemplateID, req.Args)
 		if err != nil {
 			resp.Code = CodeInvalidParam
 			resp.Message = err.Error()
-			return false
+			for _, pn := range req.PhoneNumbers {
+				resp.Fail = append(resp.Fail, FailReq{
+					PhoneNumber: pn,
+					FailReason:  err.Error(),
+					Reason:      ReasonTemplateError,
+				})
+			}
+			req.PhoneNumbers = nil
+			return true
 		}
 		req.Content = content
-		return true
+		return false
 	}
 }
 
 func (cf *ContentFilter) Filter(templateID string, args []string) (content string, err error) {
-	temp := FindTemplate(templateID)
+	return cf.content(cf.Pipeline, templateID, args)
+}
+
+func (cf *ContentFilter) content(p *Pipeline, templateID string, args []string) (content string, err error) {
+	if p == nil {
+		p = DefaultPipeline
+	}
+	temp := p.FindTemplate(templateID)
 	if temp == nil {
 		return "", errors.New("cann't find template:" + templateID)
 	}
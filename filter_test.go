@@ -1,9 +1,14 @@
 package sms
 
 import (
+	"context"
 	"github.com/garyburd/redigo/redis"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/uber-go/zap"
+	"io"
+	"io/ioutil"
+	"net"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -247,3 +252,91 @@ func BenchmarkContentFilter_Filter(b *testing.B) {
 		filter.Filter(temp.TempID, []string{"1234", "abc"})
 	}
 }
+
+func TestContentFilter_FilterFunc(t *testing.T) {
+	temp, err := buildTestTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipeline()
+	p.RegisterTemplate(temp.TempID, temp)
+	filter := (&ContentFilter{Pipeline: p}).FilterFunc()
+
+	req := &SMSReq{TemplateID: temp.TempID, PhoneNumbers: []string{"1000"}, Args: []string{"1234", "abc"}}
+	resp := &SMSResp{}
+	exit := filter(&Context{}, req, resp)
+	assert.False(t, exit)
+	assert.Equal(t, "[1234] XX验证码，30分钟内有效【abc】", req.Content)
+	assert.Empty(t, resp.Fail)
+
+	req = &SMSReq{TemplateID: temp.TempID, PhoneNumbers: []string{"1000"}, Args: []string{"0123", "abc"}}
+	resp = &SMSResp{}
+	exit = filter(&Context{}, req, resp)
+	assert.True(t, exit)
+	assert.Empty(t, req.PhoneNumbers)
+	assert.Equal(t, CodeInvalidParam, resp.Code)
+	assert.Equal(t, []FailReq{{PhoneNumber: "1000", FailReason: "invalid arg:0123", Reason: ReasonTemplateError}}, resp.Fail)
+}
+
+func TestRateLimitFilterRedis_FailReason(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedis(pool, 1, 1, 10*time.Second)
+	filter.KeyExpireSec = 5
+	key := ""
+	filter.KeyFunc = func(req *SMSReq, i int) string {
+		if key == "" {
+			key = "reason" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		}
+		return key
+	}
+
+	ctx := &Context{Logger: zap.NewJSON()}
+	pns, failed := filter.Filter(ctx, &SMSReq{PhoneNumbers: []string{"126", "126"}})
+	assert.Equal(t, []string{"126"}, pns)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, ReasonRateLimited, failed[0].Reason)
+	assert.True(t, failed[0].Retryable)
+	assert.Equal(t, 10*time.Second, failed[0].RetryAfter)
+}
+
+// 慢的redis不会使过滤器超过请求的截止时间
+func TestRedisFilters_Deadline(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			// 读取命令但不回复
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.DialTimeout("tcp", lis.Addr().String(), time.Second, 5*time.Second, 5*time.Second)
+		},
+	}
+	defer pool.Close()
+
+	limiters := map[string]RateLimiter{
+		"watch":   NewRateLimitFilterRedis(pool, 1, 1, time.Second),
+		"counter": NewRateLimitFilterRedisCounter(pool, 1, 60),
+		"script":  NewRateLimitFilterRedisScript(pool, 1, 1, time.Second),
+	}
+	limiters["quota"], err = NewRateLimitFilterRedisQuota(pool, "quota:", QuotaTier{Window: time.Minute, Limit: 1})
+	require.NoError(t, err)
+	for name, limiter := range limiters {
+		c, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		pns, failed := limiter.Filter(&Context{Context: c}, &SMSReq{PhoneNumbers: []string{"1000000", "1000001"}})
+		cancel()
+		assert.True(t, time.Since(start) < time.Second, name)
+		assert.Empty(t, pns, name)
+		assert.Equal(t, 2, len(failed), name)
+	}
+}
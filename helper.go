@@ -1,8 +1,11 @@
 package sms
 
 import (
+	"context"
+	"github.com/garyburd/redigo/redis"
 	"runtime"
 	"strconv"
+	"time"
 )
 
 func caller() string {
@@ -12,3 +15,43 @@ func caller() string {
 	}
 	return "<?>"
 }
+
+// redisTimeout 返回c的截止时间之前剩余的时间，没有截止时间时ok为false，已经超过时返回c的错误
+func redisTimeout(c context.Context) (timeout time.Duration, ok bool, err error) {
+	deadline, ok := c.Deadline()
+	if !ok {
+		return 0, false, nil
+	}
+	timeout = deadline.Sub(time.Now())
+	if timeout <= 0 {
+		if err = c.Err(); err == nil {
+			err = context.DeadlineExceeded
+		}
+		return 0, true, err
+	}
+	return timeout, true, nil
+}
+
+// redisDo 执行redis命令，c有截止时间时最多等待到截止时间
+func redisDo(c context.Context, conn redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	timeout, ok, err := redisTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return conn.Do(cmd, args...)
+	}
+	return redis.DoWithTimeout(conn, timeout, cmd, args...)
+}
+
+// redisReceive 接收pipeline中的下一个回复，c有截止时间时最多等待到截止时间
+func redisReceive(c context.Context, conn redis.Conn) (interface{}, error) {
+	timeout, ok, err := redisTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return conn.Receive()
+	}
+	return redis.ReceiveWithTimeout(conn, timeout)
+}
@@ -0,0 +1,324 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InboundMessage 用户发来的短信(上行短信)
+type InboundMessage struct {
+	Sender      string // 接收该短信的Sender名字，见SenderName
+	PhoneNumber string // 用户的号码
+	To          string // 用户发送到的号码，比如服务号加扩展码
+	Content     string
+	MsgID       string // 短信服务商的消息ID
+	RefMsgID    string // 短信服务商提供的被回复短信的MsgID
+	RefID       string // 关联的发送请求的SMSResp.ID，InboundRouter会尽量补全
+	Time        time.Time
+}
+
+// InboundHandler 处理上行短信
+type InboundHandler interface {
+	HandleInbound(c context.Context, msg *InboundMessage) error
+}
+
+type InboundHandlerFunc func(c context.Context, msg *InboundMessage) error
+
+func (f InboundHandlerFunc) HandleInbound(c context.Context, msg *InboundMessage) error {
+	return f(c, msg)
+}
+
+// InboundRouter 按关键字或正则表达式将上行短信交给对应的InboundHandler。
+// 关键字和短信内容的第一个词比较，不区分大小写，优先于正则表达式，正则表达式按注册顺序匹配。
+// Messages和Conversations不为空时用于补全InboundMessage.RefID
+type InboundRouter struct {
+	Messages      MessageStore
+	Conversations ConversationStore
+	DefaultRegion string // 在Conversations中查找前把号码转换成E.164格式，和NormalizeFilter的DefaultRegion一致
+	Logger        zap.Logger
+	Verify        RequestVerifier // Handler收到请求时先校验，为空时不校验，此时Handler需要放在有认证的入口之后
+
+	keywords map[string]InboundHandler
+	patterns []inboundPattern
+	fallback InboundHandler
+	sync.RWMutex
+}
+
+type inboundPattern struct {
+	re      *regexp.Regexp
+	handler InboundHandler
+}
+
+func NewInboundRouter(logger zap.Logger) *InboundRouter {
+	if logger == nil {
+		logger = zap.NewJSON()
+	}
+	return &InboundRouter{
+		Logger:   logger,
+		keywords: make(map[string]InboundHandler),
+	}
+}
+
+func (ir *InboundRouter) HandleKeyword(keyword string, h InboundHandler) {
+	ir.Lock()
+	if ir.keywords == nil {
+		ir.keywords = make(map[string]InboundHandler)
+	}
+	ir.keywords[strings.ToUpper(keyword)] = h
+	ir.Unlock()
+}
+
+func (ir *InboundRouter) HandleRegexp(re *regexp.Regexp, h InboundHandler) {
+	ir.Lock()
+	ir.patterns = append(ir.patterns, inboundPattern{re: re, handler: h})
+	ir.Unlock()
+}
+
+// HandleDefault 设置没有匹配到关键字和正则表达式时的InboundHandler
+func (ir *InboundRouter) HandleDefault(h InboundHandler) {
+	ir.Lock()
+	ir.fallback = h
+	ir.Unlock()
+}
+
+func (ir *InboundRouter) route(content string) InboundHandler {
+	ir.RLock()
+	defer ir.RUnlock()
+	if fields := strings.Fields(content); len(fields) > 0 {
+		if h, ok := ir.keywords[strings.ToUpper(fields[0])]; ok {
+			return h
+		}
+	}
+	for _, p := range ir.patterns {
+		if p.re.MatchString(content) {
+			return p.handler
+		}
+	}
+	return ir.fallback
+}
+
+// link 补全msg.RefID，优先使用RefMsgID对应的消息，其次是最近一次发给该号码的请求
+func (ir *InboundRouter) link(c context.Context, msg *InboundMessage) {
+	if msg.RefID != "" {
+		return
+	}
+	if msg.RefMsgID != "" && ir.Messages != nil {
+		if id, err := ir.Messages.Lookup(msg.Sender, msg.RefMsgID); err == nil {
+			msg.RefID = id
+			return
+		}
+	}
+	if ir.Conversations == nil {
+		return
+	}
+	// ConversationFilter在NormalizeFilter之后时记录的是E.164格式的号码，先用它查找
+	pns := []string{msg.PhoneNumber}
+	if e164, err := NormalizeNumber(msg.PhoneNumber, ir.DefaultRegion, false); err == nil && e164 != msg.PhoneNumber {
+		pns = []string{e164, msg.PhoneNumber}
+	}
+	for _, pn := range pns {
+		id, err := ir.Conversations.Last(c, pn)
+		if err == nil {
+			msg.RefID = id
+			return
+		}
+		if err != ErrNotFound {
+			ir.Logger.Warn("cann't find conversation", zap.String("phoneNumber", pn), zap.Error(err))
+			return
+		}
+	}
+}
+
+// HandleInbound 实现InboundHandler，没有匹配的InboundHandler时只记录日志
+func (ir *InboundRouter) HandleInbound(c context.Context, msg *InboundMessage) error {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	ir.link(c, msg)
+	h := ir.route(msg.Content)
+	if h == nil {
+		ir.Logger.Info(
+			"unhandled inbound sms",
+			zap.String("sender", msg.Sender),
+			zap.String("phoneNumber", msg.PhoneNumber),
+			zap.String("refID", msg.RefID),
+		)
+		return nil
+	}
+	return h.HandleInbound(c, msg)
+}
+
+// InboundParser 从短信服务商的推送请求中解析上行短信
+type InboundParser func(r *http.Request) ([]*InboundMessage, error)
+
+// JSONInboundParser 解析JSON格式的上行短信，请求体可以是一个InboundMessage对象或数组
+func JSONInboundParser(r *http.Request) ([]*InboundMessage, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*InboundMessage
+	if err = json.Unmarshal(data, &msgs); err == nil {
+		return msgs, nil
+	}
+	msg := &InboundMessage{}
+	if err = json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return []*InboundMessage{msg}, nil
+}
+
+// Handler 返回接收上行短信的http.Handler，parser为空时使用JSONInboundParser，短信的Sender总是设置为sender，不使用推送的内容。
+// Verify校验失败时返回401，无法解析时返回400，InboundHandler返回错误时返回500让短信服务商重新推送
+func (ir *InboundRouter) Handler(sender string, parser InboundParser) http.Handler {
+	if parser == nil {
+		parser = JSONInboundParser
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxPushBodySize)
+		if ir.Verify != nil {
+			if err := ir.Verify(r); err != nil {
+				ir.Logger.Warn("reject inbound sms", zap.String("sender", sender), zap.Error(err))
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		msgs, err := parser(r)
+		if err != nil {
+			ir.Logger.Warn("cann't parse inbound sms", zap.String("sender", sender), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, msg := range msgs {
+			msg.Sender = sender
+			if err = ir.HandleInbound(r.Context(), msg); err != nil {
+				ir.Logger.Error(
+					"cann't handle inbound sms",
+					zap.String("sender", msg.Sender),
+					zap.String("msgID", msg.MsgID),
+					zap.Error(err),
+				)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// ConversationStore 记录最近一次发给每个号码的请求ID，用于把上行短信关联到发送的请求
+type ConversationStore interface {
+	Put(c context.Context, phoneNumber, id string) error
+	Last(c context.Context, phoneNumber string) (id string, err error) // 没有记录或已过期时返回ErrNotFound
+}
+
+// ConversationFilter 在ConversationStore中记录通过了之前所有过滤器的号码，通常注册为最后一个过滤器
+type ConversationFilter struct {
+	Store ConversationStore
+}
+
+func (cf *ConversationFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		for _, pn := range req.PhoneNumbers {
+			if err := cf.Store.Put(ctx, pn, resp.ID); err != nil {
+				ctx.Logger.Warn("cann't put conversation", zap.String("id", resp.ID), zap.Error(err))
+				break
+			}
+		}
+		return false
+	}
+}
+
+// MemoryConversationStore 保存在内存中的ConversationStore，TTL大于0时记录在TTL之后过期。
+// 过期的记录在Last时才删除
+type MemoryConversationStore struct {
+	TTL time.Duration
+
+	last map[string]conversation
+	sync.RWMutex
+}
+
+type conversation struct {
+	id   string
+	time time.Time
+}
+
+func (ms *MemoryConversationStore) Put(c context.Context, phoneNumber, id string) error {
+	ms.Lock()
+	if ms.last == nil {
+		ms.last = make(map[string]conversation)
+	}
+	ms.last[phoneNumber] = conversation{id: id, time: time.Now()}
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryConversationStore) Last(ctx context.Context, phoneNumber string) (string, error) {
+	ms.RLock()
+	c, ok := ms.last[phoneNumber]
+	ms.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	if ms.TTL > 0 && time.Since(c.time) > ms.TTL {
+		ms.Lock()
+		if ms.last[phoneNumber] == c {
+			delete(ms.last, phoneNumber)
+		}
+		ms.Unlock()
+		return "", ErrNotFound
+	}
+	return c.id, nil
+}
+
+// RedisConversationStore 保存在redis中的ConversationStore，键为Prefix+号码
+type RedisConversationStore struct {
+	RedisPool    *redis.Pool
+	Prefix       string
+	KeyExpireSec int
+}
+
+func NewRedisConversationStore(redisPool *redis.Pool, prefix string, ttl time.Duration) *RedisConversationStore {
+	return &RedisConversationStore{
+		RedisPool:    redisPool,
+		Prefix:       prefix,
+		KeyExpireSec: int(ttl.Seconds()),
+	}
+}
+
+func (rs *RedisConversationStore) Put(ctx context.Context, phoneNumber, id string) error {
+	c, err := rs.RedisPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if rs.KeyExpireSec > 0 {
+		_, err = redisDo(ctx, c, "SET", rs.Prefix+phoneNumber, id, "EX", rs.KeyExpireSec)
+	} else {
+		_, err = redisDo(ctx, c, "SET", rs.Prefix+phoneNumber, id)
+	}
+	return err
+}
+
+func (rs *RedisConversationStore) Last(ctx context.Context, phoneNumber string) (string, error) {
+	c, err := rs.RedisPool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	id, err := redis.String(redisDo(ctx, c, "GET", rs.Prefix+phoneNumber))
+	if err == redis.ErrNil {
+		return "", ErrNotFound
+	}
+	return id, err
+}
@@ -0,0 +1,170 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestInboundRouter() (*InboundRouter, map[string][]*InboundMessage) {
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	ir := NewInboundRouter(logger)
+
+	handled := make(map[string][]*InboundMessage)
+	handler := func(name string) InboundHandler {
+		return InboundHandlerFunc(func(c context.Context, msg *InboundMessage) error {
+			handled[name] = append(handled[name], msg)
+			return nil
+		})
+	}
+	ir.HandleKeyword("stop", handler("stop"))
+	ir.HandleKeyword("Y", handler("confirm"))
+	ir.HandleRegexp(regexp.MustCompile(`^\d{6}$`), handler("code"))
+	return ir, handled
+}
+
+func TestInboundRouter_Route(t *testing.T) {
+	ir, handled := newTestInboundRouter()
+	c := context.Background()
+
+	for _, content := range []string{"STOP", " stop please", "y", "123456", "hello", "1234567"} {
+		require.NoError(t, ir.HandleInbound(c, &InboundMessage{PhoneNumber: "1000000", Content: content}))
+	}
+	assert.Equal(t, 2, len(handled["stop"]))
+	assert.Equal(t, 1, len(handled["confirm"]))
+	assert.Equal(t, 1, len(handled["code"]))
+
+	ir.HandleDefault(InboundHandlerFunc(func(c context.Context, msg *InboundMessage) error {
+		return errors.New("unexpected " + msg.Content)
+	}))
+	assert.EqualError(t, ir.HandleInbound(c, &InboundMessage{Content: "hello"}), "unexpected hello")
+}
+
+func TestInboundRouter_Link(t *testing.T) {
+	ir, handled := newTestInboundRouter()
+	ir.Conversations = &MemoryConversationStore{TTL: time.Minute}
+
+	messages := &MemoryMessageStore{}
+	p := newTestPipeline(NameSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		for _, pn := range req.PhoneNumbers {
+			resp.Results = append(resp.Results, Result{PhoneNumber: pn, Status: StatusSent, MsgID: "m-" + resp.ID})
+		}
+		resp.Code = CodeSuccess
+	})))
+	p.Messages = messages
+	cf := &ConversationFilter{Store: ir.Conversations}
+	p.RegisterFilter("test", cf.FilterFunc())
+	ir.Messages = messages
+
+	first := p.Send(getTestReq())
+	second := p.Send(getTestReq())
+	c := context.Background()
+
+	require.NoError(t, ir.HandleInbound(c, &InboundMessage{PhoneNumber: "1000000", Content: "Y"}))
+	require.NoError(t, ir.HandleInbound(c, &InboundMessage{Sender: "test", PhoneNumber: "1000000", Content: "Y", RefMsgID: "m-" + first.ID}))
+	require.NoError(t, ir.HandleInbound(c, &InboundMessage{PhoneNumber: "1009999", Content: "Y"}))
+
+	msgs := handled["confirm"]
+	require.Equal(t, 3, len(msgs))
+	assert.Equal(t, second.ID, msgs[0].RefID)
+	assert.Equal(t, first.ID, msgs[1].RefID)
+	assert.Equal(t, "", msgs[2].RefID)
+	assert.False(t, msgs[0].Time.IsZero())
+}
+
+func TestInboundRouter_LinkNormalized(t *testing.T) {
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	ir := &InboundRouter{Logger: logger, DefaultRegion: "CN", Conversations: &MemoryConversationStore{}}
+	var handled []*InboundMessage
+	ir.HandleKeyword("Y", InboundHandlerFunc(func(c context.Context, msg *InboundMessage) error {
+		handled = append(handled, msg)
+		return nil
+	}))
+
+	p := newTestPipeline(NameSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	})))
+	p.RegisterFilter("test", (&NormalizeFilter{DefaultRegion: "CN"}).FilterFunc())
+	p.RegisterFilter("test", (&ConversationFilter{Store: ir.Conversations}).FilterFunc())
+	resp := p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13800000000"}})
+	require.Equal(t, CodeSuccess, resp.Code)
+
+	c := context.Background()
+	require.NoError(t, ir.HandleInbound(c, &InboundMessage{PhoneNumber: "13800000000", Content: "Y"}))
+	require.NoError(t, ir.HandleInbound(c, &InboundMessage{PhoneNumber: "+8613800000000", Content: "y"}))
+	require.Equal(t, 2, len(handled))
+	assert.Equal(t, resp.ID, handled[0].RefID)
+	assert.Equal(t, resp.ID, handled[1].RefID)
+}
+
+func TestInboundRouter_Handler(t *testing.T) {
+	ir, handled := newTestInboundRouter()
+	ir.HandleRegexp(regexp.MustCompile(`^fail`), InboundHandlerFunc(func(c context.Context, msg *InboundMessage) error {
+		return errors.New("fail")
+	}))
+	h := ir.Handler("test", nil)
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/mo", strings.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, post(`[{"phoneNumber":"1000000","content":"STOP"},{"phoneNumber":"1000001","content":"hi"}]`))
+	assert.Equal(t, http.StatusOK, post(`{"phoneNumber":"1000002","content":"654321"}`))
+	assert.Equal(t, http.StatusInternalServerError, post(`{"content":"fail"}`))
+	assert.Equal(t, http.StatusBadRequest, post(`?`))
+	assert.Equal(t, http.StatusOK, post(`{"sender":"other","phoneNumber":"1000003","content":"stop"}`))
+	big := `{"phoneNumber":"1000004","content":"STOP ` + strings.Repeat("x", maxPushBodySize) + `"}`
+	assert.Equal(t, http.StatusBadRequest, post(big))
+
+	require.Equal(t, 2, len(handled["stop"]))
+	assert.Equal(t, "test", handled["stop"][0].Sender)
+	assert.Equal(t, "1000000", handled["stop"][0].PhoneNumber)
+	// 推送内容中的Sender不能覆盖入口的Sender
+	assert.Equal(t, "test", handled["stop"][1].Sender)
+	assert.Equal(t, 1, len(handled["code"]))
+}
+
+func TestInboundRouter_HandlerVerify(t *testing.T) {
+	ir, handled := newTestInboundRouter()
+	ir.Verify = TokenVerifier("X-Token", "secret")
+	h := ir.Handler("test", nil)
+
+	post := func(token string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/mo", strings.NewReader(`{"phoneNumber":"1000000","content":"STOP"}`))
+		r.Header.Set("X-Token", token)
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, post("wrong"))
+	assert.Empty(t, handled["stop"])
+
+	assert.Equal(t, http.StatusOK, post("secret"))
+	assert.Equal(t, 1, len(handled["stop"]))
+}
+
+func TestRedisConversationStore(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	store := NewRedisConversationStore(pool, "conversation:"+strconv.FormatInt(time.Now().UnixNano(), 10)+":", time.Minute)
+	_, err := store.Last(context.Background(), "1000000")
+	assert.Equal(t, ErrNotFound, err)
+	require.NoError(t, store.Put(context.Background(), "1000000", "1"))
+	require.NoError(t, store.Put(context.Background(), "1000000", "2"))
+	id, err := store.Last(context.Background(), "1000000")
+	require.NoError(t, err)
+	assert.Equal(t, "2", id)
+}
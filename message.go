@@ -0,0 +1,391 @@
+package sms
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrNoMessageStore = errors.New("no message store")
+
+// Message 一次请求的当前状态，以SMSResp.ID为键保存在MessageStore中
+type Message struct {
+	ID          string
+	Category    string
+	TemplateID  string
+	CallbackURL string
+	Code        int32 // 整个请求的结果，和SMSResp.Code一致
+	Recipients  []Recipient
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Notified    bool // 是否已经回调过CallbackURL
+}
+
+// Recipient 单个号码的当前状态
+type Recipient struct {
+	Result
+	Reason    ReasonCode // 失败时的原因分类
+	Detail    string     // 失败时可读的原因
+	UpdatedAt time.Time
+}
+
+// MessageStore 保存Message。
+// Update在id对应的Message上执行fn并保存，同一个Message的并发Update不会互相覆盖，
+// id不存在时返回ErrNotFound，fn返回错误时不保存。
+// Save和Update同时记录Recipients中的MsgID，Lookup根据Sender名字和MsgID找到Message的ID
+type MessageStore interface {
+	Save(m *Message) error
+	Get(id string) (*Message, error)
+	Update(id string, fn func(m *Message) error) error
+	Lookup(sender, msgID string) (id string, err error)
+}
+
+// Query 从DefaultPipeline的MessageStore中查询id对应的消息
+func Query(id string) (*Message, error) {
+	return DefaultPipeline.Query(id)
+}
+
+func (p *Pipeline) Query(id string) (*Message, error) {
+	if p.Messages == nil {
+		return nil, ErrNoMessageStore
+	}
+	return p.Messages.Get(id)
+}
+
+// Query 从ctx.Messages中查询，为空时使用ctx.Pipeline中的
+func (ctx *Context) Query(id string) (*Message, error) {
+	if ctx.Messages != nil {
+		return ctx.Messages.Get(id)
+	}
+	p := ctx.Pipeline
+	if p == nil {
+		p = DefaultPipeline
+	}
+	return p.Query(id)
+}
+
+// recipientsOf 根据resp.Results和resp.Fail生成每个号码的状态
+func recipientsOf(resp *SMSResp, now time.Time) []Recipient {
+	fails := make(map[string]FailReq, len(resp.Fail))
+	for _, f := range resp.Fail {
+		// 号码在请求中重复时以其他原因为准
+		if _, ok := fails[f.PhoneNumber]; !ok || f.Reason != ReasonDuplicate {
+			fails[f.PhoneNumber] = f
+		}
+	}
+	recipients := make([]Recipient, len(resp.Results))
+	for i, r := range resp.Results {
+		var f FailReq
+		if r.Status == StatusFailed {
+			f = fails[r.PhoneNumber]
+		}
+		recipients[i] = Recipient{
+			Result:    r,
+			Reason:    f.Reason,
+			Detail:    f.FailReason,
+			UpdatedAt: now,
+		}
+	}
+	return recipients
+}
+
+// recordMessage 将req的发送结果保存到ctx.Messages，到达最终状态时通过ctx.Webhook回调
+func recordMessage(ctx *Context, req *SMSReq, resp *SMSResp) {
+	var m *Message
+	if ctx.Messages != nil {
+		m = saveMessage(ctx, req, resp)
+	}
+	if ctx.Webhook != nil && req.CallbackURL != "" {
+		if m == nil {
+			now := time.Now()
+			m = &Message{ID: resp.ID, CreatedAt: now}
+			setMessage(m, req, resp, now)
+		}
+		ctx.Webhook.Notify(m)
+	}
+}
+
+func setMessage(m *Message, req *SMSReq, resp *SMSResp, now time.Time) {
+	m.Category = req.Category
+	m.TemplateID = req.TemplateID
+	m.CallbackURL = req.CallbackURL
+	m.Code = resp.Code
+	m.Recipients = mergeRecipients(m.Recipients, recipientsOf(resp, now))
+	m.deriveCode()
+	m.UpdatedAt = now
+}
+
+// mergeRecipients 用recipients更新old中相同号码的状态，其他号码保持不变。
+// 延后发送的号码以原来的ID发送后，只更新原来消息中的这些号码
+func mergeRecipients(old, recipients []Recipient) []Recipient {
+	if len(old) == 0 {
+		return recipients
+	}
+	merged := append([]Recipient(nil), old...)
+	index := make(map[string]int, len(old))
+	for i, r := range old {
+		index[r.PhoneNumber] = i
+	}
+	for _, r := range recipients {
+		if i, ok := index[r.PhoneNumber]; ok {
+			merged[i] = r
+			continue
+		}
+		index[r.PhoneNumber] = len(merged)
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// saveMessage 保存req的发送结果，已经存在时(比如定时发送的请求)保留原来的CreatedAt。
+// 返回保存后的Message，失败时返回nil
+func saveMessage(ctx *Context, req *SMSReq, resp *SMSResp) (saved *Message) {
+	now := time.Now()
+	err := ctx.Messages.Update(resp.ID, func(m *Message) error {
+		setMessage(m, req, resp, now)
+		saved = m
+		return nil
+	})
+	if err == ErrNotFound {
+		saved = &Message{ID: resp.ID, CreatedAt: now}
+		setMessage(saved, req, resp, now)
+		err = ctx.Messages.Save(saved)
+	}
+	if err != nil {
+		ctx.Logger.Error("cann't save message", zap.String("id", resp.ID), zap.Error(err))
+		return nil
+	}
+	return saved
+}
+
+// deriveCode 根据每个号码的状态设置m.Code，回执为发送失败或过期的号码也算作失败，
+// 没有号码发送过并且有号码等待定时发送时为CodeScheduled
+func (m *Message) deriveCode() {
+	var sent, failed, scheduled int
+	for _, r := range m.Recipients {
+		switch r.Status {
+		case StatusFailed, StatusUndelivered, StatusExpired:
+			failed++
+		case StatusScheduled:
+			scheduled++
+		case StatusCanceled:
+		default:
+			sent++
+		}
+	}
+	switch {
+	case sent > 0 && failed == 0:
+		m.Code = CodeSuccess
+	case sent > 0:
+		m.Code = CodeSuccessPart
+	case scheduled > 0:
+		m.Code = CodeScheduled
+	case failed > 0 && (m.Code == CodeSuccess || m.Code == CodeSuccessPart || m.Code == CodeScheduled):
+		m.Code = CodeOther
+	}
+}
+
+func copyMessage(m *Message) *Message {
+	c := *m
+	c.Recipients = append([]Recipient(nil), m.Recipients...)
+	return &c
+}
+
+func msgIDKey(sender, msgID string) string {
+	return sender + ":" + msgID
+}
+
+// MemoryMessageStore 保存在内存中的MessageStore，不会自动删除
+type MemoryMessageStore struct {
+	messages map[string]*Message
+	msgIDs   map[string]string
+	sync.RWMutex
+}
+
+func (ms *MemoryMessageStore) Save(m *Message) error {
+	ms.Lock()
+	if ms.messages == nil {
+		ms.messages = make(map[string]*Message)
+		ms.msgIDs = make(map[string]string)
+	}
+	ms.put(copyMessage(m))
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryMessageStore) put(m *Message) {
+	ms.messages[m.ID] = m
+	for _, r := range m.Recipients {
+		if r.MsgID != "" {
+			ms.msgIDs[msgIDKey(r.Sender, r.MsgID)] = m.ID
+		}
+	}
+}
+
+func (ms *MemoryMessageStore) Get(id string) (*Message, error) {
+	ms.RLock()
+	m, ok := ms.messages[id]
+	ms.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMessage(m), nil
+}
+
+func (ms *MemoryMessageStore) Update(id string, fn func(m *Message) error) error {
+	ms.Lock()
+	defer ms.Unlock()
+	m, ok := ms.messages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	m = copyMessage(m)
+	if err := fn(m); err != nil {
+		return err
+	}
+	ms.put(m)
+	return nil
+}
+
+func (ms *MemoryMessageStore) Lookup(sender, msgID string) (string, error) {
+	ms.RLock()
+	id, ok := ms.msgIDs[msgIDKey(sender, msgID)]
+	ms.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	return id, nil
+}
+
+// RedisMessageStore 保存在redis中的MessageStore。
+// Message以JSON格式保存在Prefix+ID键中，MsgID到ID的映射保存在Prefix+"msgid:"+Sender+":"+MsgID键中，
+// ExpireSec大于0时Save和Update会设置过期时间
+type RedisMessageStore struct {
+	RedisPool   *redis.Pool
+	Prefix      string
+	ExpireSec   int
+	MaxTryTimes int // Update遇到并发修改时的最大尝试次数
+}
+
+func NewRedisMessageStore(redisPool *redis.Pool, prefix string, expire time.Duration) *RedisMessageStore {
+	return &RedisMessageStore{
+		RedisPool:   redisPool,
+		Prefix:      prefix,
+		ExpireSec:   int(expire.Seconds()),
+		MaxTryTimes: 5,
+	}
+}
+
+func (rs *RedisMessageStore) key(id string) string {
+	return rs.Prefix + id
+}
+
+func (rs *RedisMessageStore) msgIDKey(sender, msgID string) string {
+	return rs.Prefix + "msgid:" + msgIDKey(sender, msgID)
+}
+
+// sendSet 发送保存m和其中MsgID映射的SET命令，不等待结果
+func (rs *RedisMessageStore) sendSet(c redis.Conn, m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	rs.sendSetEx(c, rs.key(m.ID), data)
+	for _, r := range m.Recipients {
+		if r.MsgID != "" {
+			rs.sendSetEx(c, rs.msgIDKey(r.Sender, r.MsgID), m.ID)
+		}
+	}
+	return nil
+}
+
+func (rs *RedisMessageStore) sendSetEx(c redis.Conn, key string, value interface{}) {
+	if rs.ExpireSec > 0 {
+		c.Send("SET", key, value, "EX", rs.ExpireSec)
+	} else {
+		c.Send("SET", key, value)
+	}
+}
+
+func (rs *RedisMessageStore) Save(m *Message) error {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	if err := rs.sendSet(c, m); err != nil {
+		c.Do("DISCARD")
+		return err
+	}
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (rs *RedisMessageStore) get(c redis.Conn, id string) (*Message, error) {
+	data, err := redis.Bytes(c.Do("GET", rs.key(id)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (rs *RedisMessageStore) Get(id string) (*Message, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+	return rs.get(c, id)
+}
+
+func (rs *RedisMessageStore) Update(id string, fn func(m *Message) error) error {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	for i := 0; i < rs.MaxTryTimes; i++ {
+		if _, err := c.Do("WATCH", rs.key(id)); err != nil {
+			return err
+		}
+		m, err := rs.get(c, id)
+		if err == nil {
+			err = fn(m)
+		}
+		if err != nil {
+			c.Do("UNWATCH")
+			return err
+		}
+
+		c.Send("MULTI")
+		if err = rs.sendSet(c, m); err != nil {
+			c.Do("DISCARD")
+			return err
+		}
+		reply, err := c.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+		// 被其他连接修改，重试
+	}
+	return errors.New("cann't update message " + id + ",max try times:" + strconv.Itoa(rs.MaxTryTimes))
+}
+
+func (rs *RedisMessageStore) Lookup(sender, msgID string) (string, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	id, err := redis.String(c.Do("GET", rs.msgIDKey(sender, msgID)))
+	if err == redis.ErrNil {
+		return "", ErrNotFound
+	}
+	return id, err
+}
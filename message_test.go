@@ -0,0 +1,134 @@
+package sms
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testMessageStore(t *testing.T, store MessageStore) {
+	now := time.Now()
+	err := store.Save(&Message{
+		ID:         "1",
+		Category:   "test",
+		TemplateID: "0000000",
+		Code:       CodeSuccess,
+		Recipients: []Recipient{
+			{Result: Result{PhoneNumber: "1000000", Status: StatusSent, MsgID: "m0"}, UpdatedAt: now},
+			{Result: Result{PhoneNumber: "1000001", Status: StatusSent, MsgID: "m1"}, UpdatedAt: now},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	require.NoError(t, err)
+
+	_, err = store.Get("100")
+	assert.Equal(t, ErrNotFound, err)
+	err = store.Update("100", func(m *Message) error { return nil })
+	assert.Equal(t, ErrNotFound, err)
+
+	err = store.Update("1", func(m *Message) error {
+		m.Recipients[1].Status = StatusFailed
+		m.Recipients[1].Reason = ReasonProviderError
+		return nil
+	})
+	require.NoError(t, err)
+
+	// fn返回错误时不保存
+	err = store.Update("1", func(m *Message) error {
+		m.Code = CodeOther
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+
+	m, err := store.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "test", m.Category)
+	assert.Equal(t, CodeSuccess, m.Code)
+	require.Equal(t, 2, len(m.Recipients))
+	assert.Equal(t, "m0", m.Recipients[0].MsgID)
+	assert.Equal(t, StatusFailed, m.Recipients[1].Status)
+	assert.Equal(t, ReasonProviderError, m.Recipients[1].Reason)
+	assert.True(t, now.Equal(m.CreatedAt))
+
+	id, err := store.Lookup("", "m1")
+	require.NoError(t, err)
+	assert.Equal(t, "1", id)
+	_, err = store.Lookup("other", "m1")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryMessageStore(t *testing.T) {
+	testMessageStore(t, &MemoryMessageStore{})
+}
+
+func TestRedisMessageStore(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	prefix := "message:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	testMessageStore(t, NewRedisMessageStore(pool, prefix, time.Minute))
+}
+
+func TestPipeline_Query(t *testing.T) {
+	p := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	}))
+	p.RegisterFilter("test", func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		resp.Fail = append(resp.Fail, NewFailReq(req.PhoneNumbers[0], ErrExceedLimit))
+		req.PhoneNumbers = req.PhoneNumbers[1:]
+		return
+	})
+
+	_, err := p.Query("1")
+	assert.Equal(t, ErrNoMessageStore, err)
+
+	p.Messages = &MemoryMessageStore{}
+	resp := p.Send(getTestReq())
+	require.Equal(t, CodeSuccessPart, resp.Code)
+
+	m, err := p.Query(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, resp.ID, m.ID)
+	assert.Equal(t, "test", m.Category)
+	assert.Equal(t, "0000000", m.TemplateID)
+	assert.Equal(t, CodeSuccessPart, m.Code)
+	require.Equal(t, 3, len(m.Recipients))
+	for _, r := range m.Recipients {
+		if r.PhoneNumber == "1000000" {
+			assert.Equal(t, StatusFailed, r.Status)
+			assert.Equal(t, ReasonRateLimited, r.Reason)
+		} else {
+			assert.Equal(t, StatusSent, r.Status)
+		}
+	}
+	assert.False(t, m.CreatedAt.IsZero())
+}
+
+func TestScheduler_Messages(t *testing.T) {
+	store := &MemoryMessageStore{}
+	ctx := &Context{Logger: zap.NewJSON(), Messages: store}
+	s := NewScheduler(ctx, &MemoryScheduleStore{})
+
+	req := getTestReq()
+	req.Delay = time.Hour
+	id, err := s.Schedule(req)
+	require.NoError(t, err)
+
+	m, err := ctx.Query(id)
+	require.NoError(t, err)
+	assert.Equal(t, CodeScheduled, m.Code)
+	assert.Equal(t, StatusScheduled, m.Recipients[0].Status)
+
+	ok, err := s.Cancel(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	m, err = ctx.Query(id)
+	require.NoError(t, err)
+	assert.Equal(t, CodeCanceled, m.Code)
+	assert.Equal(t, StatusCanceled, m.Recipients[2].Status)
+}
@@ -0,0 +1,248 @@
+package sms
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// CountryRule 一个国家或地区的号码规则
+type CountryRule struct {
+	Region      string   // ISO 3166-1两位代码
+	Code        string   // 国家码
+	TrunkPrefix string   // 国内拨号时的前缀，比如英国的0
+	Lengths     []int    // 去掉国家码和TrunkPrefix后允许的长度
+	Prefixes    []string // 去掉国家码和TrunkPrefix后允许的开头，为空时不限制
+	TimeZone    string   // IANA时区，有多个时区的国家使用人口最多的时区
+}
+
+// Valid 判断去掉国家码和TrunkPrefix后的号码是否合法
+func (cr *CountryRule) Valid(national string) bool {
+	if !isDigits(national) {
+		return false
+	}
+	validLength := false
+	for _, l := range cr.Lengths {
+		if len(national) == l {
+			validLength = true
+			break
+		}
+	}
+	if !validLength {
+		return false
+	}
+	if len(cr.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range cr.Prefixes {
+		if strings.HasPrefix(national, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountryRules 内置的手机号规则，可以在初始化时修改或添加，开始使用之后修改需要调用UpdateCountryRules
+var CountryRules = map[string]*CountryRule{
+	"CN": {Region: "CN", Code: "86", Lengths: []int{11}, Prefixes: []string{"13", "14", "15", "16", "17", "18", "19"}, TimeZone: "Asia/Shanghai"},
+	"HK": {Region: "HK", Code: "852", Lengths: []int{8}, Prefixes: []string{"4", "5", "6", "7", "9"}, TimeZone: "Asia/Hong_Kong"},
+	"MO": {Region: "MO", Code: "853", Lengths: []int{8}, Prefixes: []string{"6"}, TimeZone: "Asia/Macau"},
+	"TW": {Region: "TW", Code: "886", TrunkPrefix: "0", Lengths: []int{9}, Prefixes: []string{"9"}, TimeZone: "Asia/Taipei"},
+	"US": {Region: "US", Code: "1", Lengths: []int{10}, Prefixes: []string{"2", "3", "4", "5", "6", "7", "8", "9"}, TimeZone: "America/New_York"},
+	"GB": {Region: "GB", Code: "44", TrunkPrefix: "0", Lengths: []int{10}, Prefixes: []string{"7"}, TimeZone: "Europe/London"},
+	"JP": {Region: "JP", Code: "81", TrunkPrefix: "0", Lengths: []int{10}, Prefixes: []string{"70", "80", "90"}, TimeZone: "Asia/Tokyo"},
+	"KR": {Region: "KR", Code: "82", TrunkPrefix: "0", Lengths: []int{9, 10}, Prefixes: []string{"10"}, TimeZone: "Asia/Seoul"},
+	"SG": {Region: "SG", Code: "65", Lengths: []int{8}, Prefixes: []string{"8", "9"}, TimeZone: "Asia/Singapore"},
+	"AU": {Region: "AU", Code: "61", TrunkPrefix: "0", Lengths: []int{9}, Prefixes: []string{"4"}, TimeZone: "Australia/Sydney"},
+}
+
+// sortedCountryRules 按countryOf匹配顺序排好的CountryRules，第一次使用时生成
+var (
+	sortedCountryRules atomic.Value
+	sortCountryMu      sync.Mutex
+)
+
+// UpdateCountryRules 重新生成countryOf使用的规则，修改CountryRules之后调用
+func UpdateCountryRules() {
+	sortCountryMu.Lock()
+	defer sortCountryMu.Unlock()
+	sortedCountryRules.Store(sortCountryRules())
+}
+
+// sortCountryRules 国家码长的在前，相同时按Region排序
+func sortCountryRules() []*CountryRule {
+	rules := make([]*CountryRule, 0, len(CountryRules))
+	for _, cr := range CountryRules {
+		rules = append(rules, cr)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Code) != len(rules[j].Code) {
+			return len(rules[i].Code) > len(rules[j].Code)
+		}
+		return rules[i].Region < rules[j].Region
+	})
+	return rules
+}
+
+func countryRules() []*CountryRule {
+	if rules, ok := sortedCountryRules.Load().([]*CountryRule); ok {
+		return rules
+	}
+	sortCountryMu.Lock()
+	defer sortCountryMu.Unlock()
+	rules, ok := sortedCountryRules.Load().([]*CountryRule)
+	if !ok {
+		rules = sortCountryRules()
+		sortedCountryRules.Store(rules)
+	}
+	return rules
+}
+
+// countryOf 返回e164(不含+)的国家码对应的规则，国家码最长的优先匹配。
+// 多个地区共用国家码时(比如1)返回Region最小的一个
+func countryOf(digits string) *CountryRule {
+	for _, cr := range countryRules() {
+		if cr.Code != "" && len(cr.Code) < len(digits) && strings.HasPrefix(digits, cr.Code) {
+			return cr
+		}
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanNumber 去掉空格、-、括号和点
+func cleanNumber(raw string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.', '\t':
+			return -1
+		}
+		return r
+	}, raw)
+}
+
+// NormalizeNumber 将号码转换成E.164格式(+国家码号码)，没有国家码的号码按defaultRegion解析。
+// 国家码不在CountryRules中时，strict为true返回ErrInvalidNumber，否则只检查长度
+func NormalizeNumber(raw, defaultRegion string, strict bool) (string, error) {
+	n := cleanNumber(raw)
+	switch {
+	case strings.HasPrefix(n, "+"):
+		return normalizeInternational(n[1:], strict)
+	case strings.HasPrefix(n, "00"):
+		return normalizeInternational(n[2:], strict)
+	}
+
+	cr := CountryRules[defaultRegion]
+	if cr == nil {
+		return "", ErrInvalidNumber
+	}
+	national := n
+	if cr.TrunkPrefix != "" {
+		national = strings.TrimPrefix(national, cr.TrunkPrefix)
+	}
+	if cr.Valid(national) {
+		return "+" + cr.Code + national, nil
+	}
+	// 省略了+的国际号码，比如8613800000000
+	if strings.HasPrefix(n, cr.Code) && cr.Valid(n[len(cr.Code):]) {
+		return "+" + n, nil
+	}
+	return "", ErrInvalidNumber
+}
+
+func normalizeInternational(digits string, strict bool) (string, error) {
+	if !isDigits(digits) || len(digits) > 15 {
+		return "", ErrInvalidNumber
+	}
+	cr := countryOf(digits)
+	if cr == nil {
+		if strict || len(digits) < 8 {
+			return "", ErrInvalidNumber
+		}
+		return "+" + digits, nil
+	}
+	national := digits[len(cr.Code):]
+	if cr.TrunkPrefix != "" && !cr.Valid(national) {
+		// 有些号码写成+44 (0)7...
+		national = strings.TrimPrefix(national, cr.TrunkPrefix)
+	}
+	if !cr.Valid(national) {
+		return "", ErrInvalidNumber
+	}
+	return "+" + cr.Code + national, nil
+}
+
+// NormalizeFilter 将号码转换成E.164格式，不合法的号码加入resp.Fail。
+// 需要注册在限速等以号码为键的过滤器之前，使同一个号码的不同写法使用同一个键。
+// 同一个号码的多种写法只发送一次，通过FilterFunc使用时resp.Results和resp.Fail中仍然是请求中原来的号码
+type NormalizeFilter struct {
+	DefaultRegion string // 没有国家码的号码所属的地区，比如CN
+	Strict        bool   // 是否拒绝CountryRules中没有的国家码
+}
+
+func (nf *NormalizeFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, origs, failed := nf.normalize(req)
+		for i, pn := range pns {
+			for _, orig := range origs[i] {
+				ctx.rewriteNumber(orig, pn)
+			}
+		}
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (nf *NormalizeFilter) Filter(req *SMSReq) ([]string, []FailReq) {
+	pns, _, failed := nf.normalize(req)
+	return pns, failed
+}
+
+// normalize 返回去重后的E.164号码，origs[i]是转换成pns[i]的原来的号码
+func (nf *NormalizeFilter) normalize(req *SMSReq) (pns []string, origs [][]string, failed []FailReq) {
+	pns = make([]string, 0, len(req.PhoneNumbers))
+	index := make(map[string]int, len(req.PhoneNumbers))
+	for _, pn := range req.PhoneNumbers {
+		e164, err := NormalizeNumber(pn, nf.DefaultRegion, nf.Strict)
+		if err != nil {
+			failed = append(failed, NewFailReq(pn, err))
+			continue
+		}
+		if i, ok := index[e164]; ok {
+			origs[i] = append(origs[i], pn)
+			continue
+		}
+		index[e164] = len(pns)
+		pns = append(pns, e164)
+		origs = append(origs, []string{pn})
+	}
+	return pns, origs, failed
+}
+
+// E164KeyFunc 返回以E.164格式的号码为键的KeyFunc，用于没有注册NormalizeFilter的限速过滤器。
+// 不合法的号码使用原来的号码
+func E164KeyFunc(defaultRegion string) func(req *SMSReq, i int) string {
+	return func(req *SMSReq, i int) string {
+		pn := req.PhoneNumbers[i]
+		if e164, err := NormalizeNumber(pn, defaultRegion, false); err == nil {
+			return e164
+		}
+		return pn
+	}
+}
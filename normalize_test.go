@@ -0,0 +1,157 @@
+package sms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	for raw, e164 := range map[string]string{
+		"13800000000":        "+8613800000000",
+		"+86 138-0000-0000":  "+8613800000000",
+		"008613800000000":    "+8613800000000",
+		"8613800000000":      "+8613800000000",
+		"(138) 0000 0000":    "+8613800000000",
+		"+852 9123 4567":     "+85291234567",
+		"+44 (0)7911 123456": "+447911123456",
+		"+1 415-555-2671":    "+14155552671",
+		"+380501234567":      "+380501234567",
+	} {
+		n, err := NormalizeNumber(raw, "CN", false)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, e164, n, raw)
+		}
+	}
+
+	for _, raw := range []string{"", "abc", "1380000000", "12800000000", "+86 1380000000", "+8612345", "+3805012345678901"} {
+		_, err := NormalizeNumber(raw, "CN", false)
+		assert.Equal(t, ErrInvalidNumber, err, raw)
+	}
+	_, err := NormalizeNumber("+380501234567", "CN", true)
+	assert.Equal(t, ErrInvalidNumber, err)
+	_, err = NormalizeNumber("13800000000", "XX", false)
+	assert.Equal(t, ErrInvalidNumber, err)
+
+	n, err := NormalizeNumber("07911 123456", "GB", true)
+	require.NoError(t, err)
+	assert.Equal(t, "+447911123456", n)
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	nf := &NormalizeFilter{DefaultRegion: "CN"}
+	pns, failed := nf.Filter(&SMSReq{PhoneNumbers: []string{"+86 138-0000-0000", "1234", "13900000000"}})
+	assert.Equal(t, []string{"+8613800000000", "+8613900000000"}, pns)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, "1234", failed[0].PhoneNumber)
+	assert.Equal(t, ReasonInvalidNumber, failed[0].Reason)
+	assert.False(t, failed[0].Retryable)
+}
+
+func TestNormalizeFilter_Results(t *testing.T) {
+	var sent []string
+	p := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		sent = append(sent, req.PhoneNumbers...)
+		for _, pn := range req.PhoneNumbers {
+			resp.Results = append(resp.Results, Result{PhoneNumber: pn, Status: StatusSent, MsgID: "m-" + pn})
+		}
+		resp.Code = CodeSuccess
+	}))
+	p.Logger.SetLevel(zap.ErrorLevel)
+	nf := &NormalizeFilter{DefaultRegion: "CN"}
+	p.RegisterFilter("test", nf.FilterFunc())
+	p.RegisterFilter("test", func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns := req.PhoneNumbers[:0]
+		for _, pn := range req.PhoneNumbers {
+			if pn == "+8613900000000" {
+				resp.Fail = append(resp.Fail, NewFailReq(pn, ErrExceedLimit))
+			} else {
+				pns = append(pns, pn)
+			}
+		}
+		req.PhoneNumbers = pns
+		return len(pns) == 0
+	})
+
+	resp := p.Send(&SMSReq{
+		Category:     "test",
+		PhoneNumbers: []string{"+86 138-0000-0000", "13800000000", "1234", "13900000000"},
+	})
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	// 同一个号码的不同写法只发送一次，结果中是请求中原来的号码
+	assert.Equal(t, []string{"+8613800000000"}, sent)
+	results := make(map[string]Result)
+	for _, r := range resp.Results {
+		results[r.PhoneNumber] = r
+	}
+	require.Equal(t, 4, len(results))
+	assert.Equal(t, StatusSent, results["+86 138-0000-0000"].Status)
+	assert.Equal(t, "m-+8613800000000", results["13800000000"].MsgID)
+	assert.Equal(t, StatusFailed, results["1234"].Status)
+	assert.Equal(t, StatusFailed, results["13900000000"].Status)
+
+	failed := make(map[string]FailReq)
+	for _, f := range resp.Fail {
+		failed[f.PhoneNumber] = f
+	}
+	require.Equal(t, 2, len(failed))
+	assert.Equal(t, ReasonInvalidNumber, failed["1234"].Reason)
+	assert.Equal(t, ReasonRateLimited, failed["13900000000"].Reason)
+}
+
+func TestCountryOf(t *testing.T) {
+	CountryRules["XA"] = &CountryRule{Region: "XA", Code: "85", Lengths: []int{8}}
+	CountryRules["CA"] = &CountryRule{Region: "CA", Code: "1", Lengths: []int{10}}
+	UpdateCountryRules()
+	defer func() {
+		delete(CountryRules, "XA")
+		delete(CountryRules, "CA")
+		UpdateCountryRules()
+	}()
+
+	// 最长的国家码优先，共用国家码时结果固定
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "HK", countryOf("85291234567").Region)
+		assert.Equal(t, "XA", countryOf("8512345678").Region)
+		assert.Equal(t, "CA", countryOf("14155552671").Region)
+	}
+	assert.Nil(t, countryOf("380501234567"))
+	// 排好序的规则只生成一次
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { countryOf("85291234567") }))
+}
+
+func TestNormalizeFilter_RateLimitKey(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	suffix := ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	limiter := NewRateLimitFilterRedisCounter(pool, 1, 60)
+	limiter.KeyFunc = func(req *SMSReq, i int) string {
+		return req.PhoneNumbers[i] + suffix
+	}
+	nf := &NormalizeFilter{DefaultRegion: "CN"}
+	ctx := &Context{Logger: zap.NewJSON()}
+
+	send := func(pn string) []FailReq {
+		req := &SMSReq{PhoneNumbers: []string{pn}}
+		var resp SMSResp
+		nf.FilterFunc()(ctx, req, &resp)
+		limiter.FilterFunc()(ctx, req, &resp)
+		return resp.Fail
+	}
+	assert.Empty(t, send("+86 138-0000-0000"))
+	failed := send("008613800000000")
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, ReasonRateLimited, failed[0].Reason)
+	assert.Equal(t, 1, len(send("13800000000")))
+
+	// 没有NormalizeFilter时使用E164KeyFunc
+	keyFunc := E164KeyFunc("CN")
+	req := &SMSReq{PhoneNumbers: []string{"+86 138-0000-0000", "008613800000000", "1234"}}
+	assert.Equal(t, "+8613800000000", keyFunc(req, 0))
+	assert.Equal(t, "+8613800000000", keyFunc(req, 1))
+	assert.Equal(t, "1234", keyFunc(req, 2))
+}
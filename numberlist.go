@@ -0,0 +1,209 @@
+package sms
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrBlacklisted    = errors.New("blacklisted")
+	ErrNotWhitelisted = errors.New("not whitelisted")
+)
+
+// NumberRules 一组号码规则，每条规则是精确的号码(13800000000)、以*结尾的前缀(170*)或/包围的正则表达式(/^1700\d+$/)，
+// 空行和以#开头的行被忽略。匹配前先去掉号码中的空格等符号，精确和前缀匹配时带有+86、0086或86国家码的号码也会去掉国家码再比较
+type NumberRules struct {
+	numbers  map[string]bool
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+func ParseNumberRules(lines []string) (*NumberRules, error) {
+	rules := &NumberRules{numbers: make(map[string]bool)}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			re, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				return nil, err
+			}
+			rules.patterns = append(rules.patterns, re)
+		case strings.HasSuffix(line, "*"):
+			rules.prefixes = append(rules.prefixes, strings.TrimSuffix(line, "*"))
+		default:
+			rules.numbers[line] = true
+		}
+	}
+	return rules, nil
+}
+
+func (r *NumberRules) Match(phoneNumber string) bool {
+	phoneNumber = cleanNumber(phoneNumber)
+	national := trimChinaCode(phoneNumber)
+	if r.numbers[phoneNumber] || r.numbers[national] {
+		return true
+	}
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(phoneNumber, p) || strings.HasPrefix(national, p) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(phoneNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// RulesSource 加载规则的文本行，比如NumberRules和SensitiveWords
+type RulesSource interface {
+	Load() ([]string, error)
+}
+
+// StaticRules 内存中的规则
+type StaticRules []string
+
+func (s StaticRules) Load() ([]string, error) {
+	return s, nil
+}
+
+// FileRules 从文件中加载规则，每行一条
+type FileRules string
+
+func (f FileRules) Load() ([]string, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// RedisRules 从redis集合Key中加载规则
+type RedisRules struct {
+	RedisPool *redis.Pool
+	Key       string
+}
+
+func (rr *RedisRules) Load() ([]string, error) {
+	c := rr.RedisPool.Get()
+	defer c.Close()
+	return redis.Strings(c.Do("SMEMBERS", rr.Key))
+}
+
+// NumberList 从Source加载的NumberRules，Reload可以在运行时重新加载
+type NumberList struct {
+	Source RulesSource
+	rules  atomic.Value
+}
+
+func NewNumberList(source RulesSource) (*NumberList, error) {
+	l := &NumberList{Source: source}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload 重新加载规则，失败时继续使用原来的规则
+func (l *NumberList) Reload() error {
+	lines, err := l.Source.Load()
+	if err != nil {
+		return err
+	}
+	rules, err := ParseNumberRules(lines)
+	if err != nil {
+		return err
+	}
+	l.rules.Store(rules)
+	return nil
+}
+
+// ReloadEvery 每隔interval重新加载一次规则，直到c结束
+func (l *NumberList) ReloadEvery(c context.Context, interval time.Duration, logger zap.Logger) {
+	reloadEvery(c, interval, logger, l.Reload)
+}
+
+func reloadEvery(c context.Context, interval time.Duration, logger zap.Logger, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := reload(); err != nil && logger != nil {
+				logger.Error("cann't reload rules", zap.Error(err))
+			}
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+func (l *NumberList) Match(phoneNumber string) bool {
+	rules, _ := l.rules.Load().(*NumberRules)
+	return rules != nil && rules.Match(phoneNumber)
+}
+
+// filter 去掉remove为true的号码，以err为原因加入resp.Fail
+func (l *NumberList) filter(ctx *Context, req *SMSReq, resp *SMSResp, remove func(pn string) bool, err error) (exit bool) {
+	if e := ctx.Err(); e != nil {
+		resp.Fail = appendFailed(resp.Fail, req.PhoneNumbers, e)
+		req.PhoneNumbers = nil
+		return true
+	}
+	newNumbers := make([]string, 0, len(req.PhoneNumbers))
+	for _, pn := range req.PhoneNumbers {
+		if remove(pn) {
+			resp.Fail = append(resp.Fail, NewFailReq(pn, err))
+		} else {
+			newNumbers = append(newNumbers, pn)
+		}
+	}
+	req.PhoneNumbers = newNumbers
+	return len(newNumbers) == 0
+}
+
+// BlacklistFilter 去掉匹配List的号码
+type BlacklistFilter struct {
+	List *NumberList
+}
+
+func (bf *BlacklistFilter) FilterFunc() Filter {
+	return bf.Filter
+}
+
+func (bf *BlacklistFilter) Filter(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+	return bf.List.filter(ctx, req, resp, bf.List.Match, ErrBlacklisted)
+}
+
+// WhitelistFilter 只保留匹配List的号码，比如测试环境只允许发给内部员工
+type WhitelistFilter struct {
+	List *NumberList
+}
+
+func (wf *WhitelistFilter) FilterFunc() Filter {
+	return wf.Filter
+}
+
+func (wf *WhitelistFilter) Filter(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+	return wf.List.filter(ctx, req, resp, func(pn string) bool {
+		return !wf.List.Match(pn)
+	}, ErrNotWhitelisted)
+}
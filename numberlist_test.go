@@ -0,0 +1,108 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNumberRules_Match(t *testing.T) {
+	rules, err := ParseNumberRules([]string{"# comment", "", " 13800000000 ", "170*", `/^1710\d{7}$/`})
+	require.NoError(t, err)
+
+	for pn, match := range map[string]bool{
+		"13800000000":       true,
+		"138 0000 0000":     true,
+		"+86 138-0000-0000": true,
+		"170-1234-5678":     true,
+		"171 0123 4567":     true,
+		"+8613800000000":    true,
+		"13800000001":       false,
+		"17012345678":       true,
+		"008617012345678":   true,
+		"17101234567":       true,
+		"17111234567":       false,
+		"":                  false,
+	} {
+		assert.Equal(t, match, rules.Match(pn), pn)
+	}
+
+	_, err = ParseNumberRules([]string{"/(/"})
+	assert.Error(t, err)
+}
+
+func TestBlacklistFilter(t *testing.T) {
+	list, err := NewNumberList(StaticRules{"1000001", "1000002"})
+	require.NoError(t, err)
+	bf := &BlacklistFilter{List: list}
+
+	ctx := &Context{}
+	req, resp := getTestReq(), &SMSResp{}
+	assert.False(t, bf.Filter(ctx, req, resp))
+	assert.Equal(t, []string{"1000000"}, req.PhoneNumbers)
+	require.Equal(t, 2, len(resp.Fail))
+	assert.Equal(t, ReasonBlacklisted, resp.Fail[0].Reason)
+	assert.False(t, resp.Fail[0].Retryable)
+
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, resp = getTestReq(), &SMSResp{}
+	assert.True(t, bf.Filter(&Context{Context: c}, req, resp))
+	assert.Empty(t, req.PhoneNumbers)
+	require.Equal(t, 3, len(resp.Fail))
+	assert.Equal(t, ReasonTimeout, resp.Fail[0].Reason)
+}
+
+func TestWhitelistFilter_Reload(t *testing.T) {
+	f, err := ioutil.TempFile("", "whitelist")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("1000000\n")
+	f.Close()
+
+	list, err := NewNumberList(FileRules(f.Name()))
+	require.NoError(t, err)
+	wf := &WhitelistFilter{List: list}
+
+	ctx := &Context{}
+	req, resp := getTestReq(), &SMSResp{}
+	assert.False(t, wf.Filter(ctx, req, resp))
+	assert.Equal(t, []string{"1000000"}, req.PhoneNumbers)
+	require.Equal(t, 2, len(resp.Fail))
+	assert.Equal(t, ReasonNotWhitelist, resp.Fail[0].Reason)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("100000*\n"), 0644))
+	require.NoError(t, list.Reload())
+	req, resp = getTestReq(), &SMSResp{}
+	assert.False(t, wf.Filter(ctx, req, resp))
+	assert.Equal(t, 3, len(req.PhoneNumbers))
+	assert.Empty(t, resp.Fail)
+
+	// 加载失败时继续使用原来的规则
+	os.Remove(f.Name())
+	assert.Error(t, list.Reload())
+	assert.True(t, list.Match("1000002"))
+}
+
+func TestNumberList_Redis(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+	c := pool.Get()
+	defer c.Close()
+
+	key := "blacklist:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err := c.Do("SADD", key, "1000000", "/^100000[12]$/")
+	require.NoError(t, err)
+
+	list, err := NewNumberList(&RedisRules{RedisPool: pool, Key: key})
+	require.NoError(t, err)
+	req, resp := getTestReq(), &SMSResp{}
+	assert.True(t, (&BlacklistFilter{List: list}).Filter(&Context{}, req, resp))
+	assert.Empty(t, req.PhoneNumbers)
+	assert.Equal(t, 3, len(resp.Fail))
+}
@@ -0,0 +1,213 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"sync"
+)
+
+// OptOutAll 退订所有category的短信
+const OptOutAll = "_ALL"
+
+var ErrOptedOut = errors.New("opted out")
+
+// OptOutStore 保存退订的号码，scope为category或OptOutAll
+type OptOutStore interface {
+	Add(scope, phoneNumber string) error
+	Remove(scope, phoneNumber string) error
+	Contains(c context.Context, scope string, phoneNumbers []string) ([]bool, error)
+}
+
+// OptOutFilter 去掉退订了req.Category或OptOutAll的号码。
+// Exempt中的category(比如验证码等事务类短信)不检查。
+// 退订和检查都先用KeyFunc转换号码，上行短信的号码和发送请求中的号码格式不同时也能匹配
+type OptOutFilter struct {
+	Store         OptOutStore
+	Exempt        map[string]bool
+	DefaultRegion string                          // 没有国家码的号码所属的地区，见OptOutKey
+	KeyFunc       func(phoneNumber string) string // 为空时使用OptOutKey
+}
+
+// OptOutKey 将号码转换成E.164格式，没有国家码的号码按defaultRegion解析，
+// 比如defaultRegion为CN时13800000000、+86 138-0000-0000和8613800000000是同一个键。
+// 无法转换的号码只去掉空格等符号
+func OptOutKey(phoneNumber, defaultRegion string) string {
+	if e164, err := NormalizeNumber(phoneNumber, defaultRegion, false); err == nil {
+		return e164
+	}
+	return cleanNumber(phoneNumber)
+}
+
+func (f *OptOutFilter) key(phoneNumber string) string {
+	if f.KeyFunc != nil {
+		return f.KeyFunc(phoneNumber)
+	}
+	return OptOutKey(phoneNumber, f.DefaultRegion)
+}
+
+func NewOptOutFilter(store OptOutStore, defaultRegion string, exempt ...string) *OptOutFilter {
+	f := &OptOutFilter{
+		Store:         store,
+		Exempt:        make(map[string]bool, len(exempt)),
+		DefaultRegion: defaultRegion,
+	}
+	for _, category := range exempt {
+		f.Exempt[category] = true
+	}
+	return f
+}
+
+// OptOut 退订scope下的短信，scope为OptOutAll时退订所有短信
+func (f *OptOutFilter) OptOut(scope, phoneNumber string) error {
+	return f.Store.Add(scope, f.key(phoneNumber))
+}
+
+// OptIn 取消退订
+func (f *OptOutFilter) OptIn(scope, phoneNumber string) error {
+	return f.Store.Remove(scope, f.key(phoneNumber))
+}
+
+func (f *OptOutFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, failed := f.Filter(ctx, req)
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (f *OptOutFilter) Filter(ctx *Context, req *SMSReq) ([]string, []FailReq) {
+	if f.Exempt[req.Category] || len(req.PhoneNumbers) == 0 {
+		return req.PhoneNumbers, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	keys := make([]string, len(req.PhoneNumbers))
+	for i, pn := range req.PhoneNumbers {
+		keys[i] = f.key(pn)
+	}
+	all, err := f.Store.Contains(ctx, OptOutAll, keys)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+	category, err := f.Store.Contains(ctx, req.Category, keys)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	var (
+		newNumbers = make([]string, 0, len(req.PhoneNumbers))
+		failed     []FailReq
+	)
+	for i, pn := range req.PhoneNumbers {
+		if all[i] || category[i] {
+			failed = append(failed, NewFailReq(pn, ErrOptedOut))
+		} else {
+			newNumbers = append(newNumbers, pn)
+		}
+	}
+	return newNumbers, failed
+}
+
+// InboundHandler 返回将发送者退订scope的InboundHandler，可以注册为InboundRouter中"STOP"等关键字的处理
+func (f *OptOutFilter) InboundHandler(scope string) InboundHandler {
+	return InboundHandlerFunc(func(c context.Context, msg *InboundMessage) error {
+		return f.OptOut(scope, msg.PhoneNumber)
+	})
+}
+
+// MemoryOptOutStore 保存在内存中的OptOutStore
+type MemoryOptOutStore struct {
+	scopes map[string]map[string]bool
+	sync.RWMutex
+}
+
+func (ms *MemoryOptOutStore) Add(scope, phoneNumber string) error {
+	ms.Lock()
+	if ms.scopes == nil {
+		ms.scopes = make(map[string]map[string]bool)
+	}
+	numbers, ok := ms.scopes[scope]
+	if !ok {
+		numbers = make(map[string]bool)
+		ms.scopes[scope] = numbers
+	}
+	numbers[phoneNumber] = true
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryOptOutStore) Remove(scope, phoneNumber string) error {
+	ms.Lock()
+	delete(ms.scopes[scope], phoneNumber)
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryOptOutStore) Contains(c context.Context, scope string, phoneNumbers []string) ([]bool, error) {
+	contains := make([]bool, len(phoneNumbers))
+	ms.RLock()
+	numbers := ms.scopes[scope]
+	for i, pn := range phoneNumbers {
+		contains[i] = numbers[pn]
+	}
+	ms.RUnlock()
+	return contains, nil
+}
+
+// RedisOptOutStore 保存在redis中的OptOutStore，每个scope的号码保存在Prefix+scope集合中
+type RedisOptOutStore struct {
+	RedisPool *redis.Pool
+	Prefix    string
+}
+
+func NewRedisOptOutStore(redisPool *redis.Pool, prefix string) *RedisOptOutStore {
+	return &RedisOptOutStore{
+		RedisPool: redisPool,
+		Prefix:    prefix,
+	}
+}
+
+func (rs *RedisOptOutStore) Add(scope, phoneNumber string) error {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	_, err := c.Do("SADD", rs.Prefix+scope, phoneNumber)
+	return err
+}
+
+func (rs *RedisOptOutStore) Remove(scope, phoneNumber string) error {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	_, err := c.Do("SREM", rs.Prefix+scope, phoneNumber)
+	return err
+}
+
+func (rs *RedisOptOutStore) Contains(ctx context.Context, scope string, phoneNumbers []string) ([]bool, error) {
+	c, err := rs.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	key := rs.Prefix + scope
+	for _, pn := range phoneNumbers {
+		c.Send("SISMEMBER", key, pn)
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	contains := make([]bool, len(phoneNumbers))
+	for i := range phoneNumbers {
+		ok, err := redis.Bool(redisReceive(ctx, c))
+		if err != nil {
+			return nil, err
+		}
+		contains[i] = ok
+	}
+	return contains, nil
+}
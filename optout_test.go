@@ -0,0 +1,81 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testOptOutFilter(t *testing.T, store OptOutStore) {
+	f := NewOptOutFilter(store, "CN", "otp")
+	require.NoError(t, f.OptOut("test", "1000000"))
+	require.NoError(t, f.OptOut(OptOutAll, "1000002"))
+	require.NoError(t, f.OptOut("other", "1000001"))
+
+	ctx := &Context{}
+	pns, failed := f.Filter(ctx, getTestReq())
+	assert.Equal(t, []string{"1000001"}, pns)
+	require.Equal(t, 2, len(failed))
+	assert.Equal(t, "1000000", failed[0].PhoneNumber)
+	assert.Equal(t, ReasonOptedOut, failed[0].Reason)
+	assert.False(t, failed[0].Retryable)
+	assert.Equal(t, "1000002", failed[1].PhoneNumber)
+
+	req := getTestReq()
+	req.Category = "otp"
+	pns, failed = f.Filter(ctx, req)
+	assert.Equal(t, req.PhoneNumbers, pns)
+	assert.Empty(t, failed)
+
+	require.NoError(t, f.OptIn("test", "1000000"))
+	require.NoError(t, f.InboundHandler("test").HandleInbound(context.Background(), &InboundMessage{PhoneNumber: "1000001", Content: "STOP"}))
+	pns, failed = f.Filter(ctx, getTestReq())
+	assert.Equal(t, []string{"1000000"}, pns)
+	assert.Equal(t, 2, len(failed))
+
+	// 上行短信的号码带国家码，发送时不带国家码
+	require.NoError(t, f.InboundHandler("test").HandleInbound(context.Background(), &InboundMessage{PhoneNumber: "+8613800000000", Content: "STOP"}))
+	req = getTestReq()
+	req.PhoneNumbers = []string{"13800000000", "138 0000 0001"}
+	pns, failed = f.Filter(ctx, req)
+	assert.Equal(t, []string{"138 0000 0001"}, pns)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, "13800000000", failed[0].PhoneNumber)
+	require.NoError(t, f.OptIn("test", "8613800000000"))
+	pns, failed = f.Filter(ctx, req)
+	assert.Equal(t, req.PhoneNumbers, pns)
+	assert.Empty(t, failed)
+
+	// 其他地区的号码也使用E.164格式的键
+	us := NewOptOutFilter(store, "US")
+	require.NoError(t, us.OptOut("test", "+12125551234"))
+	req = getTestReq()
+	req.PhoneNumbers = []string{"2125551234", "(212) 555-1235"}
+	pns, failed = us.Filter(ctx, req)
+	assert.Equal(t, []string{"(212) 555-1235"}, pns)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, "2125551234", failed[0].PhoneNumber)
+}
+
+func TestOptOutKey(t *testing.T) {
+	assert.Equal(t, "+8613800000000", OptOutKey("138 0000 0000", "CN"))
+	assert.Equal(t, "+8613800000000", OptOutKey("8613800000000", "CN"))
+	assert.Equal(t, "+12125551234", OptOutKey("2125551234", "US"))
+	assert.Equal(t, "+12125551234", OptOutKey("+1 212-555-1234", "CN"))
+	assert.Equal(t, "1000000", OptOutKey("100 0000", "CN"))
+}
+
+func TestOptOutFilter_Memory(t *testing.T) {
+	testOptOutFilter(t, &MemoryOptOutStore{})
+}
+
+func TestOptOutFilter_Redis(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	prefix := "optout:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	testOptOutFilter(t, NewRedisOptOutStore(pool, prefix))
+}
@@ -0,0 +1,187 @@
+package sms
+
+import (
+	"context"
+	"sync"
+
+	"github.com/uber-go/zap"
+)
+
+// DefaultPipeline 包级别的RegisterFilter、RegisterTemplate等函数操作的Pipeline
+var DefaultPipeline = NewPipeline()
+
+// Pipeline 一套独立的发送配置，拥有自己的过滤器、模板、Selector和IDGen。
+// 同一个进程中可以同时存在多个配置不同的Pipeline，零值可以直接使用
+type Pipeline struct {
+	Logger     zap.Logger
+	Selector   Selector
+	IDGen      IDGen
+	Retry      *RetryPolicy
+	DeadLetter DeadLetterStore
+	Messages   MessageStore
+	Webhook    *Webhook
+
+	initOnce sync.Once
+
+	filters    map[string][]Filter
+	filtersRWM sync.RWMutex
+
+	templates    map[string]*SMSTemplate
+	templatesRWM sync.RWMutex
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		filters:   make(map[string][]Filter),
+		templates: make(map[string]*SMSTemplate),
+	}
+}
+
+func (p *Pipeline) RegisterFilter(category string, f Filter) {
+	if f == nil {
+		return
+	}
+	p.filtersRWM.Lock()
+	if p.filters == nil {
+		p.filters = make(map[string][]Filter)
+	}
+	p.filters[category] = append(p.filters[category], f)
+	p.filtersRWM.Unlock()
+}
+
+func (p *Pipeline) ResetFilters(category string, newFilters []Filter) {
+	p.filtersRWM.Lock()
+	if p.filters == nil {
+		p.filters = make(map[string][]Filter)
+	}
+	p.filters[category] = newFilters
+	p.filtersRWM.Unlock()
+}
+
+func (p *Pipeline) RegisterTemplate(id string, t *SMSTemplate) {
+	p.templatesRWM.Lock()
+	if p.templates == nil {
+		p.templates = make(map[string]*SMSTemplate)
+	}
+	_, exist := p.templates[id]
+	if exist && t == nil { // 删除
+		delete(p.templates, id)
+	} else if t != nil { // 替换或新增
+		p.templates[id] = t
+	}
+	p.templatesRWM.Unlock()
+}
+
+func (p *Pipeline) FindTemplate(id string) *SMSTemplate {
+	p.templatesRWM.RLock()
+	t := p.templates[id]
+	p.templatesRWM.RUnlock()
+	return t
+}
+
+func (p *Pipeline) Send(req *SMSReq) *SMSResp {
+	return p.SendContext(context.Background(), req)
+}
+
+// SendContext 使用Pipeline自己的Logger、Selector和IDGen发送
+func (p *Pipeline) SendContext(c context.Context, req *SMSReq) *SMSResp {
+	p.initOnce.Do(p.init)
+	ctx := &Context{
+		Context:    c,
+		Logger:     p.Logger,
+		Selector:   p.Selector,
+		IDGen:      p.IDGen,
+		Retry:      p.Retry,
+		DeadLetter: p.DeadLetter,
+		Messages:   p.Messages,
+		Webhook:    p.Webhook,
+		Pipeline:   p,
+	}
+	return p.send(ctx, req, "")
+}
+
+func (p *Pipeline) init() {
+	if p.Logger == nil {
+		p.Logger = zap.NewJSON()
+	}
+	if p.Selector == nil {
+		p.Selector = &RandomSelector{}
+	}
+	if p.IDGen == nil {
+		idGen, err := NewDefaultIDGen()
+		if err != nil {
+			p.Logger.Error("cann't new default id generator", zap.Error(err))
+		} else {
+			p.IDGen = idGen
+		}
+	}
+}
+
+// send 依次执行全局和req.Category下的过滤器，然后选择Sender发送。
+// id为空时由ctx.IDGen生成，返回前根据每个号码的结果设置resp.Code
+func (p *Pipeline) send(ctx *Context, req *SMSReq, id string) (resp *SMSResp) {
+	if id == "" {
+		id = ctx.IDGen.Next()
+	}
+	resp = &SMSResp{
+		ID: id,
+	}
+	var (
+		senderName string
+		orig       *SMSReq
+		meta       = SMSReq{Category: req.Category, TemplateID: req.TemplateID, CallbackURL: req.CallbackURL}
+	)
+	if ctx.DeadLetter != nil {
+		orig = copyReq(req)
+	}
+	ctx.rewritten = nil
+	defer func() {
+		completeResults(req, resp, senderName)
+		restoreNumbers(ctx, resp)
+		deriveCode(resp)
+		if orig != nil {
+			putDeadLetter(ctx, orig, resp)
+		}
+		recordMessage(ctx, &meta, resp)
+	}()
+
+	p.filtersRWM.RLock()
+	exit := false
+	for _, f := range p.filters[FilterGlobal] {
+		if exit = f(ctx, req, resp); exit {
+			break
+		}
+	}
+	if !exit {
+		for _, f := range p.filters[req.Category] {
+			if exit = f(ctx, req, resp); exit {
+				break
+			}
+		}
+	}
+	p.filtersRWM.RUnlock()
+	if ctx.Err() != nil {
+		setTimeout(ctx, resp)
+		return
+	}
+	if exit || len(req.PhoneNumbers) == 0 {
+		return
+	}
+
+	sender, errCode, err := ctx.Selector.Select(ctx, req)
+	if err != nil {
+		resp.Code = errCode
+		resp.Message = err.Error()
+		return
+	}
+	if ctx.Retry != nil && ctx.Retry.MaxAttempts > 1 {
+		sender = withRetry(sender, ctx.Retry)
+	}
+	senderName = resultSender(sender)
+	sender.Send(ctx, req, resp)
+	if ctx.Err() != nil && resp.Code != CodeSuccess {
+		setTimeout(ctx, resp)
+	}
+
+	return
+}
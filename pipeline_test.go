@@ -0,0 +1,89 @@
+package sms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"testing"
+)
+
+func newTestPipeline(sender Sender) *Pipeline {
+	selector := &RandomSelector{}
+	selector.AddSender("test", sender)
+
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.InfoLevel)
+
+	p := NewPipeline()
+	p.Logger = logger
+	p.Selector = selector
+	return p
+}
+
+func TestPipeline_ZeroValue(t *testing.T) {
+	var content string
+	selector := &RandomSelector{}
+	selector.AddSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		content = req.Content
+		resp.Code = CodeSuccess
+	}))
+	p := &Pipeline{Selector: selector}
+	p.RegisterTemplate("001", &SMSTemplate{TempID: "001", Temp: "zero %s", NumArgs: 1, valueCheckers: []ValueChecker{nil}})
+	p.RegisterFilter("test", (&ContentFilter{}).FilterFunc())
+	p.Logger = zap.NewJSON()
+	p.Logger.SetLevel(zap.ErrorLevel)
+
+	resp := p.Send(&SMSReq{Category: "test", TemplateID: "001", PhoneNumbers: []string{"1000000"}, Args: []string{"x"}})
+	assert.Equal(t, CodeSuccess, resp.Code)
+	assert.Equal(t, "zero x", content)
+
+	p = &Pipeline{}
+	p.ResetFilters("test", nil)
+	assert.Nil(t, p.FindTemplate("001"))
+}
+
+func TestPipeline_Isolation(t *testing.T) {
+	var contents [2]string
+	p1 := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		contents[0] = req.Content
+		resp.Code = CodeSuccess
+	}))
+	p2 := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		contents[1] = req.Content
+		resp.Code = CodeSuccess
+	}))
+
+	p1.RegisterTemplate("001", &SMSTemplate{TempID: "001", Temp: "p1 %s", NumArgs: 1, valueCheckers: []ValueChecker{nil}})
+	p2.RegisterTemplate("001", &SMSTemplate{TempID: "001", Temp: "p2 %s", NumArgs: 1, valueCheckers: []ValueChecker{nil}})
+	cf := &ContentFilter{}
+	p1.RegisterFilter("test", cf.FilterFunc())
+	p2.RegisterFilter("test", cf.FilterFunc())
+	p2.RegisterFilter("test", func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		resp.Fail = append(resp.Fail, FailReq{PhoneNumber: req.PhoneNumbers[0], FailReason: "p2"})
+		req.PhoneNumbers = req.PhoneNumbers[1:]
+		return
+	})
+
+	newReq := func() *SMSReq {
+		return &SMSReq{
+			Category:     "test",
+			TemplateID:   "001",
+			PhoneNumbers: []string{"1000000", "1000001"},
+			Args:         []string{"x"},
+		}
+	}
+
+	resp1 := p1.Send(newReq())
+	resp2 := p2.Send(newReq())
+
+	assert.Equal(t, CodeSuccess, resp1.Code)
+	assert.Empty(t, resp1.Fail)
+	assert.Equal(t, CodeSuccessPart, resp2.Code)
+	require.Equal(t, 1, len(resp2.Fail))
+	assert.Equal(t, "p1 x", contents[0])
+	assert.Equal(t, "p2 x", contents[1])
+
+	// 包级别的默认Pipeline不受影响
+	assert.Nil(t, FindTemplate("001"))
+	assert.Empty(t, DefaultPipeline.filters["test"])
+}
@@ -0,0 +1,238 @@
+package sms
+
+import (
+	"errors"
+	"github.com/uber-go/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrQuietHours = errors.New("quiet hours")
+
+// ErrInvalidWindow DailyWindow的Start等于End，或者不在[0, 24h)中
+var ErrInvalidWindow = errors.New("invalid daily window")
+
+// DailyWindow 每天的一个时间段，Start和End是距离当地零点的时长。
+// Start大于End时跨越零点，比如21:00到次日08:00
+type DailyWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Valid 检查Start和End都在[0, 24h)中且不相等
+func (w DailyWindow) Valid() bool {
+	return w.Start >= 0 && w.Start < 24*time.Hour && w.End >= 0 && w.End < 24*time.Hour && w.Start != w.End
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+// Contains 判断t在t.Location()中的当地时间是否在时间段内
+func (w DailyWindow) Contains(t time.Time) bool {
+	d := sinceMidnight(t)
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// NextStart 返回t之后(含t)时间段下一次开始的时间
+func (w DailyWindow) NextStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	if start.Before(t) {
+		start = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	}
+	return start
+}
+
+// DeferFunc 接收免打扰时段中的号码，在at之后再发送。req只包含需要延后的号码，id是这些号码原来所在请求的ID，
+// 延后发送的结果应该以id更新原来的消息，见Scheduler.DeferFunc
+type DeferFunc func(ctx *Context, req *SMSReq, id string, at time.Time) error
+
+// QuietHoursFilter 只在号码所在地的允许时段内发送，没有设置允许时段的category(比如验证码)不受限制。
+// 不在允许时段的号码交给Defer延后发送，结果的Status为StatusScheduled；
+// Defer为空时加入resp.Fail，RetryAfter为到下一个允许时段的时长
+type QuietHoursFilter struct {
+	DefaultRegion string                                  // 解析没有国家码的号码，见NormalizeNumber
+	Location      func(phoneNumber string) *time.Location // 为空时根据CountryRules中的TimeZone确定
+	Defer         DeferFunc
+	Now           func() time.Time // 为空时使用time.Now
+
+	windows   map[string][]DailyWindow
+	locations map[string]*time.Location
+	sync.RWMutex
+}
+
+func NewQuietHoursFilter(defaultRegion string) *QuietHoursFilter {
+	return &QuietHoursFilter{
+		DefaultRegion: defaultRegion,
+	}
+}
+
+// SetWindows 设置category允许发送的时段，windows为空时category不受限制，有不合法的时段时返回ErrInvalidWindow
+func (qf *QuietHoursFilter) SetWindows(category string, windows ...DailyWindow) error {
+	for _, w := range windows {
+		if !w.Valid() {
+			return ErrInvalidWindow
+		}
+	}
+	qf.Lock()
+	if qf.windows == nil {
+		qf.windows = make(map[string][]DailyWindow)
+	}
+	if len(windows) == 0 {
+		delete(qf.windows, category)
+	} else {
+		qf.windows[category] = windows
+	}
+	qf.Unlock()
+	return nil
+}
+
+// location 返回号码所在地的时区，无法确定时使用DefaultRegion的时区，都没有时使用time.Local
+func (qf *QuietHoursFilter) location(phoneNumber string) *time.Location {
+	if qf.Location != nil {
+		if loc := qf.Location(phoneNumber); loc != nil {
+			return loc
+		}
+		return time.Local
+	}
+	cr := CountryRules[qf.DefaultRegion]
+	if e164, err := NormalizeNumber(phoneNumber, qf.DefaultRegion, false); err == nil {
+		if c := countryOf(e164[1:]); c != nil {
+			cr = c
+		}
+	}
+	if cr == nil || cr.TimeZone == "" {
+		return time.Local
+	}
+
+	qf.RLock()
+	loc, ok := qf.locations[cr.TimeZone]
+	qf.RUnlock()
+	if ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(cr.TimeZone)
+	if err != nil {
+		loc = time.Local
+	}
+	qf.Lock()
+	if qf.locations == nil {
+		qf.locations = make(map[string]*time.Location)
+	}
+	qf.locations[cr.TimeZone] = loc
+	qf.Unlock()
+	return loc
+}
+
+// nextAllowed 返回号码下一次允许发送的时间，当前允许发送时返回零值
+func nextAllowed(windows []DailyWindow, now time.Time) time.Time {
+	var next time.Time
+	for _, w := range windows {
+		if w.Contains(now) {
+			return time.Time{}
+		}
+		if start := w.NextStart(now); next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+func (qf *QuietHoursFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, deferred, failed := qf.Filter(ctx, req, resp.ID)
+		req.PhoneNumbers = pns
+		resp.Results = append(resp.Results, deferred...)
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+// Filter 返回可以发送的号码、交给Defer延后发送的号码的结果和失败的号码，id是req的ID
+func (qf *QuietHoursFilter) Filter(ctx *Context, req *SMSReq, id string) ([]string, []Result, []FailReq) {
+	qf.RLock()
+	windows := qf.windows[req.Category]
+	qf.RUnlock()
+	if len(windows) == 0 {
+		return req.PhoneNumbers, nil, nil
+	}
+
+	now := time.Now()
+	if qf.Now != nil {
+		now = qf.Now()
+	}
+	var (
+		newNumbers = make([]string, 0, len(req.PhoneNumbers))
+		quiet      = make(map[time.Time][]string)
+		failed     []FailReq
+	)
+	for _, pn := range req.PhoneNumbers {
+		next := nextAllowed(windows, now.In(qf.location(pn)))
+		if next.IsZero() {
+			newNumbers = append(newNumbers, pn)
+			continue
+		}
+		if qf.Defer == nil {
+			f := NewFailReq(pn, ErrQuietHours)
+			f.RetryAfter = next.Sub(now)
+			failed = append(failed, f)
+			continue
+		}
+		next = next.UTC()
+		quiet[next] = append(quiet[next], pn)
+	}
+
+	// 按时间顺序交给Defer，每个时间一次
+	times := make([]time.Time, 0, len(quiet))
+	for at := range quiet {
+		times = append(times, at)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var deferred []Result
+	for _, at := range times {
+		// 延后的请求使用原来的号码，发送时重新经过NormalizeFilter等过滤器，结果才能和原来的消息合并
+		subReq := *req
+		subReq.PhoneNumbers = ctx.originalNumbers(quiet[at])
+		if err := qf.Defer(ctx, &subReq, id, at); err != nil {
+			ctx.Logger.Error("cann't defer sms", zap.Time("at", at), zap.Error(err))
+			failed = appendFailed(failed, quiet[at], err)
+			continue
+		}
+		for _, pn := range quiet[at] {
+			deferred = append(deferred, Result{
+				PhoneNumber: pn,
+				Status:      StatusScheduled,
+			})
+		}
+	}
+	return newNumbers, deferred, failed
+}
+
+// DeferFunc 返回将号码交给Scheduler在at发送的DeferFunc。
+// 延后的号码到时间后以原来请求的ID发送，发送结果更新到原来的消息中，回调和查询都使用原来的ID
+func (s *Scheduler) DeferFunc() DeferFunc {
+	return func(ctx *Context, req *SMSReq, id string, at time.Time) error {
+		r := copyReq(req)
+		r.SendAt = at
+		r.Delay = 0
+		sr := &ScheduledReq{
+			ID:     s.ctx.IDGen.Next(),
+			Req:    r,
+			At:     at,
+			Parent: id,
+		}
+		err := s.Store.Add(sr)
+		if err == nil {
+			ctx.Logger.Info("sms deferred", zap.String("id", id), zap.String("scheduleID", sr.ID), zap.Time("at", at))
+		}
+		return err
+	}
+}
@@ -0,0 +1,218 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"testing"
+	"time"
+)
+
+func TestDailyWindow(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	at := func(h, m int) time.Time {
+		return time.Date(2017, 3, 1, h, m, 0, 0, loc)
+	}
+	day := DailyWindow{Start: 8 * time.Hour, End: 21 * time.Hour}
+	night := DailyWindow{Start: 21 * time.Hour, End: 8 * time.Hour}
+
+	assert.True(t, day.Contains(at(8, 0)))
+	assert.False(t, day.Contains(at(21, 0)))
+	assert.True(t, night.Contains(at(23, 0)))
+	assert.True(t, night.Contains(at(3, 0)))
+	assert.False(t, night.Contains(at(12, 0)))
+
+	assert.Equal(t, at(8, 0), day.NextStart(at(3, 0)))
+	assert.Equal(t, at(8, 0).AddDate(0, 0, 1), day.NextStart(at(22, 0)))
+	assert.Equal(t, at(21, 0), night.NextStart(at(21, 0)))
+
+	assert.True(t, day.Valid())
+	assert.True(t, night.Valid())
+	qf := NewQuietHoursFilter("CN")
+	for _, w := range []DailyWindow{
+		{Start: 8 * time.Hour, End: 8 * time.Hour},
+		{Start: -time.Hour, End: 8 * time.Hour},
+		{Start: 8 * time.Hour, End: 24 * time.Hour},
+		{Start: 0, End: 0},
+	} {
+		assert.False(t, w.Valid(), "%v", w)
+		assert.Equal(t, ErrInvalidWindow, qf.SetWindows("test", day, w))
+	}
+	assert.Empty(t, qf.windows)
+}
+
+func newTestQuietHoursFilter(now time.Time) *QuietHoursFilter {
+	qf := NewQuietHoursFilter("CN")
+	qf.Location = func(pn string) *time.Location {
+		if pn == "+447911123456" {
+			return time.UTC
+		}
+		return time.FixedZone("UTC+8", 8*3600)
+	}
+	qf.Now = func() time.Time { return now }
+	qf.SetWindows("marketing", DailyWindow{Start: 8 * time.Hour, End: 21 * time.Hour})
+	return qf
+}
+
+func TestQuietHoursFilter_Reject(t *testing.T) {
+	// 北京时间23:00，伦敦15:00
+	now := time.Date(2017, 3, 1, 15, 0, 0, 0, time.UTC)
+	qf := newTestQuietHoursFilter(now)
+	ctx := &Context{Logger: zap.NewJSON()}
+
+	req := &SMSReq{Category: "marketing", PhoneNumbers: []string{"13800000000", "+447911123456"}}
+	pns, deferred, failed := qf.Filter(ctx, req, "")
+	assert.Equal(t, []string{"+447911123456"}, pns)
+	assert.Empty(t, deferred)
+	require.Equal(t, 1, len(failed))
+	assert.Equal(t, ReasonQuietHours, failed[0].Reason)
+	assert.True(t, failed[0].Retryable)
+	assert.Equal(t, 9*time.Hour, failed[0].RetryAfter)
+
+	req.Category = "otp"
+	pns, _, failed = qf.Filter(ctx, req, "")
+	assert.Equal(t, 2, len(pns))
+	assert.Empty(t, failed)
+}
+
+func TestQuietHoursFilter_Defer(t *testing.T) {
+	now := time.Date(2017, 3, 1, 15, 0, 0, 0, time.UTC)
+	p := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	}))
+	qf := newTestQuietHoursFilter(now)
+	var deferred []*SMSReq
+	qf.Defer = func(ctx *Context, req *SMSReq, id string, at time.Time) error {
+		assert.Equal(t, now.Add(9*time.Hour), at)
+		if req.PhoneNumbers[0] == "13900000000" {
+			return errors.New("scheduler down")
+		}
+		r := copyReq(req)
+		r.SendAt = at
+		deferred = append(deferred, r)
+		return nil
+	}
+	require.NoError(t, qf.SetWindows("test", DailyWindow{Start: 8 * time.Hour, End: 21 * time.Hour}))
+	p.RegisterFilter("test", qf.FilterFunc())
+
+	resp := p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13800000000", "+447911123456"}})
+	assert.Equal(t, CodeSuccess, resp.Code)
+	assert.Empty(t, resp.Fail)
+	require.Equal(t, 1, len(deferred))
+	assert.Equal(t, []string{"13800000000"}, deferred[0].PhoneNumbers)
+	for _, r := range resp.Results {
+		if r.PhoneNumber == "13800000000" {
+			assert.Equal(t, StatusScheduled, r.Status)
+		} else {
+			assert.Equal(t, StatusSent, r.Status)
+		}
+	}
+
+	resp = p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13900000000"}})
+	assert.Equal(t, CodeOther, resp.Code)
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, ReasonInternal, resp.Fail[0].Reason)
+}
+
+func TestQuietHoursFilter_CountryTimeZone(t *testing.T) {
+	qf := NewQuietHoursFilter("CN")
+	loc := qf.location("+447911123456")
+	if loc.String() != "Europe/London" {
+		t.Skip("no time zone database")
+	}
+	assert.Equal(t, "Asia/Shanghai", qf.location("13800000000").String())
+	assert.Equal(t, "Asia/Shanghai", qf.location("garbage").String())
+}
+
+func TestQuietHoursFilter_Scheduler(t *testing.T) {
+	now := time.Date(2017, 3, 1, 15, 0, 0, 0, time.UTC)
+	p := newTestPipeline(NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	})))
+	p.Logger.SetLevel(zap.ErrorLevel)
+	store := &MemoryMessageStore{}
+	p.Messages = store
+
+	s := NewScheduler(&Context{Pipeline: p}, &MemoryScheduleStore{})
+	var ids []string
+	s.OnResult = func(req *SMSReq, resp *SMSResp) {
+		ids = append(ids, resp.ID)
+	}
+	qf := newTestQuietHoursFilter(now)
+	qf.Now = func() time.Time { return now }
+	qf.Defer = s.DeferFunc()
+	require.NoError(t, qf.SetWindows("test", DailyWindow{Start: 8 * time.Hour, End: 21 * time.Hour}))
+	p.RegisterFilter("test", qf.FilterFunc())
+
+	resp := p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13800000000", "+447911123456"}})
+	assert.Equal(t, CodeSuccess, resp.Code)
+	// 所有号码都延后时没有号码发送
+	all := p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13900000000"}})
+	assert.Equal(t, CodeScheduled, all.Code)
+	m, err := store.Get(all.ID)
+	require.NoError(t, err)
+	assert.Equal(t, CodeScheduled, m.Code)
+	assert.False(t, m.Final(false))
+
+	// 到时间后以原来的ID发送，更新原来的消息
+	now = now.Add(9 * time.Hour)
+	s.release(context.Background())
+	require.Equal(t, 2, len(ids))
+	assert.Contains(t, ids, resp.ID)
+	assert.Contains(t, ids, all.ID)
+	for _, id := range []string{resp.ID, all.ID} {
+		m, err := store.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, CodeSuccess, m.Code)
+		assert.True(t, m.Final(false))
+		for _, r := range m.Recipients {
+			assert.Equal(t, StatusSent, r.Status, r.PhoneNumber)
+			assert.Equal(t, "a", r.Sender)
+		}
+	}
+	m, err = store.Get(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(m.Recipients))
+}
+
+func TestQuietHoursFilter_Normalize(t *testing.T) {
+	now := time.Date(2017, 3, 1, 15, 0, 0, 0, time.UTC)
+	var sent [][]string
+	p := newTestPipeline(NameSender("a", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		sent = append(sent, append([]string(nil), req.PhoneNumbers...))
+		resp.Code = CodeSuccess
+	})))
+	p.Logger.SetLevel(zap.ErrorLevel)
+	store := &MemoryMessageStore{}
+	p.Messages = store
+
+	s := NewScheduler(&Context{Pipeline: p}, &MemoryScheduleStore{})
+	qf := newTestQuietHoursFilter(now)
+	qf.Now = func() time.Time { return now }
+	qf.Defer = s.DeferFunc()
+	require.NoError(t, qf.SetWindows("test", DailyWindow{Start: 8 * time.Hour, End: 21 * time.Hour}))
+	p.RegisterFilter("test", (&NormalizeFilter{DefaultRegion: "CN"}).FilterFunc())
+	p.RegisterFilter("test", qf.FilterFunc())
+
+	// 同一个号码的两种写法，都在免打扰时段
+	resp := p.Send(&SMSReq{Category: "test", PhoneNumbers: []string{"13800000000", "+86 138 0000 0000"}})
+	assert.Equal(t, CodeScheduled, resp.Code)
+	m, err := store.Get(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(m.Recipients))
+
+	now = now.Add(9 * time.Hour)
+	s.release(context.Background())
+	assert.Equal(t, [][]string{{"+8613800000000"}}, sent)
+	m, err = store.Get(resp.ID)
+	require.NoError(t, err)
+	assert.Equal(t, CodeSuccess, m.Code)
+	assert.True(t, m.Final(false))
+	require.Equal(t, 2, len(m.Recipients))
+	for _, r := range m.Recipients {
+		assert.Contains(t, []string{"13800000000", "+86 138 0000 0000"}, r.PhoneNumber)
+		assert.Equal(t, StatusSent, r.Status, r.PhoneNumber)
+	}
+}
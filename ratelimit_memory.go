@@ -0,0 +1,165 @@
+package sms
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// RateLimiter 按号码限制发送频率的过滤器，Filter返回没有超过限制的号码和超过限制的号码
+type RateLimiter interface {
+	Filter(ctx *Context, req *SMSReq) ([]string, []FailReq)
+	FilterFunc() Filter
+}
+
+var (
+	_ RateLimiter = (*RateLimitFilterRedis)(nil)
+	_ RateLimiter = (*RateLimitFilterRedisCounter)(nil)
+	_ RateLimiter = (*RateLimitFilterMemory)(nil)
+)
+
+const rateLimitShards = 64
+
+// RateLimitFilterMemory 在内存中按令牌桶限制发送频率，和RateLimitFilterRedis的参数含义相同，不需要redis。
+// 键分散在多个分片中以减少锁竞争，超过IdleTimeout没有使用的键会被删除
+type RateLimitFilterMemory struct {
+	MaxTokens   int64
+	Tokens      int64
+	PerSec      int64
+	KeyFunc     func(req *SMSReq, i int) string
+	IdleTimeout time.Duration // 为0时使用令牌桶从空到满需要的时间，此时删除键不影响限速结果，不放入令牌时不删除
+
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	buckets   map[string]*tokenBucket
+	nextSweep time.Time
+	sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens int64
+	last   time.Time // 上次放入令牌的时间
+}
+
+func NewRateLimitFilterMemory(maxTokens, tokens int64, per time.Duration) *RateLimitFilterMemory {
+	return &RateLimitFilterMemory{
+		MaxTokens: maxTokens,
+		Tokens:    tokens,
+		PerSec:    int64(per.Seconds()),
+	}
+}
+
+func (rl *RateLimitFilterMemory) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, failed := rl.Filter(ctx, req)
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (rl *RateLimitFilterMemory) Filter(ctx *Context, req *SMSReq) ([]string, []FailReq) {
+	var (
+		newNumbers = make([]string, 0, len(req.PhoneNumbers))
+		failed     []FailReq
+	)
+	for i := 0; i < len(req.PhoneNumbers); i++ {
+		if err := ctx.Err(); err != nil {
+			failed = appendFailed(failed, req.PhoneNumbers[i:], err)
+			break
+		}
+		key := req.PhoneNumbers[i]
+		if rl.KeyFunc != nil {
+			key = rl.KeyFunc(req, i)
+		}
+		if ok, wait := rl.take(key, time.Now()); ok {
+			newNumbers = append(newNumbers, req.PhoneNumbers[i])
+		} else {
+			f := NewFailReq(req.PhoneNumbers[i], ErrExceedLimit)
+			f.RetryAfter = wait
+			failed = append(failed, f)
+		}
+	}
+	return newNumbers, failed
+}
+
+// interval 返回放入一个令牌需要的时间
+func (rl *RateLimitFilterMemory) interval() time.Duration {
+	if rl.Tokens <= 0 {
+		return 0
+	}
+	return time.Duration(rl.PerSec) * time.Second / time.Duration(rl.Tokens)
+}
+
+func (rl *RateLimitFilterMemory) idleTimeout() time.Duration {
+	if rl.IdleTimeout > 0 {
+		return rl.IdleTimeout
+	}
+	return rl.interval() * time.Duration(rl.MaxTokens)
+}
+
+func (rl *RateLimitFilterMemory) shard(key string) *rateLimitShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &rl.shards[h.Sum32()%rateLimitShards]
+}
+
+// take 从key的令牌桶中取一个令牌，失败时返回下一个令牌放入前需要等待的时间，不会放入令牌时为0
+func (rl *RateLimitFilterMemory) take(key string, now time.Time) (ok bool, wait time.Duration) {
+	s := rl.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = make(map[string]*tokenBucket)
+	}
+	if idle := rl.idleTimeout(); idle > 0 && now.After(s.nextSweep) {
+		for k, b := range s.buckets {
+			if now.Sub(b.last) >= idle {
+				delete(s.buckets, k)
+			}
+		}
+		s.nextSweep = now.Add(idle)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.MaxTokens, last: now}
+		s.buckets[key] = b
+	}
+
+	interval := rl.interval()
+	if interval > 0 {
+		if put := int64(now.Sub(b.last) / interval); put > 0 {
+			b.tokens += put
+			b.last = b.last.Add(time.Duration(put) * interval)
+			if b.tokens >= rl.MaxTokens {
+				b.tokens = rl.MaxTokens
+				b.last = now
+			}
+		}
+	}
+
+	if b.tokens < 1 {
+		if interval <= 0 {
+			return false, 0
+		}
+		return false, interval - now.Sub(b.last)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Len 返回当前保存的键数
+func (rl *RateLimitFilterMemory) Len() int {
+	n := 0
+	for i := range rl.shards {
+		s := &rl.shards[i]
+		s.Lock()
+		n += len(s.buckets)
+		s.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package sms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitFilterMemory_Filter(t *testing.T) {
+	rl := NewRateLimitFilterMemory(3, 1, time.Second)
+	ctx := &Context{}
+
+	sucCount := 0
+	for i := 0; i < 5; i++ {
+		pns, failed := rl.Filter(ctx, &SMSReq{PhoneNumbers: []string{"1234"}})
+		if len(failed) == 0 {
+			sucCount++
+		} else {
+			assert.Empty(t, pns)
+			assert.Equal(t, ReasonRateLimited, failed[0].Reason)
+			assert.True(t, failed[0].RetryAfter > 0 && failed[0].RetryAfter <= time.Second)
+		}
+	}
+	assert.Equal(t, 3, sucCount)
+
+	// 其他号码不受影响
+	pns, _ := rl.Filter(ctx, &SMSReq{PhoneNumbers: []string{"5678"}})
+	assert.Equal(t, []string{"5678"}, pns)
+}
+
+func TestRateLimitFilterMemory_Refill(t *testing.T) {
+	rl := NewRateLimitFilterMemory(2, 1, time.Second)
+	now := time.Now()
+
+	take := func(d time.Duration) bool {
+		ok, _ := rl.take("k", now.Add(d))
+		return ok
+	}
+	assert.True(t, take(0))
+	assert.True(t, take(0))
+	assert.False(t, take(900*time.Millisecond))
+	assert.True(t, take(1100*time.Millisecond))
+	// 不足一个令牌的时间不会丢失
+	assert.False(t, take(1900*time.Millisecond))
+	assert.True(t, take(2000*time.Millisecond))
+
+	ok, wait := rl.take("k", now.Add(2500*time.Millisecond))
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// 不放入令牌时一直失败
+	rl = NewRateLimitFilterMemory(1, 0, time.Second)
+	ok, _ = rl.take("k", now)
+	assert.True(t, ok)
+	ok, _ = rl.take("k", now.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestRateLimitFilterMemory_Evict(t *testing.T) {
+	rl := NewRateLimitFilterMemory(2, 1, time.Second)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		rl.take(strconv.Itoa(i), now)
+	}
+	require.Equal(t, 1000, rl.Len())
+
+	// 令牌桶2秒后就满了，之后访问的分片会删除空闲的键
+	for i := 0; i < 1000; i++ {
+		rl.take("new"+strconv.Itoa(i), now.Add(3*time.Second))
+	}
+	assert.Equal(t, 1000, rl.Len())
+}
+
+func TestRateLimitFilterMemory_Concurrence(t *testing.T) {
+	rl := NewRateLimitFilterMemory(10, 1, time.Hour)
+	ctx := &Context{}
+
+	var (
+		wg        sync.WaitGroup
+		succeeded int64
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				pns, _ := rl.Filter(ctx, &SMSReq{PhoneNumbers: []string{"1234", "5678"}})
+				atomic.AddInt64(&succeeded, int64(len(pns)))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(20), succeeded)
+}
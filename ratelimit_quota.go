@@ -0,0 +1,185 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var _ RateLimiter = (*RateLimitFilterRedisQuota)(nil)
+
+// ErrInvalidQuotaTier QuotaTier的Window不到1毫秒或Limit小于0
+var ErrInvalidQuotaTier = errors.New("invalid quota tier")
+
+// QuotaTier 任意Window时长内最多发送Limit条
+type QuotaTier struct {
+	Window time.Duration
+	Limit  int
+}
+
+func (t QuotaTier) String() string {
+	return strconv.Itoa(t.Limit) + " per " + t.Window.String()
+}
+
+// validTiers 检查每一级的窗口和条数，窗口按毫秒计算，不到1毫秒时键会被立即删除
+func validTiers(tiers []QuotaTier) error {
+	for _, t := range tiers {
+		if t.Window < time.Millisecond || t.Limit < 0 {
+			return ErrInvalidQuotaTier
+		}
+	}
+	return nil
+}
+
+// quotaScript 多级滑动窗口限额，KEYS[1]为有序集合，成员是每次发送，分数是发送时间(毫秒)。
+// ARGV: 当前时间(毫秒)，本次发送的成员，最大窗口(毫秒)，要删除的最大分数，之后依次是每一级的窗口(毫秒)、条数和窗口的起始分数(不含)。
+// 分数都由调用方计算，避免lua把较大的数字转换成科学计数法。
+// 所有级别都没有超过限额时才记录本次发送，返回{是否通过, 超过限额的级别(从0开始，通过时为-1), 需要等待的毫秒数}
+var quotaScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[4])
+
+for i = 5, #ARGV, 3 do
+	local window = tonumber(ARGV[i])
+	local limit = tonumber(ARGV[i + 1])
+	local from = ARGV[i + 2]
+	local count = redis.call('ZCOUNT', KEYS[1], from, '+inf')
+	if count >= limit then
+		local wait = window
+		if limit > 0 then
+			-- 窗口内第count-limit+1条移出窗口后才能发送
+			local first = redis.call('ZRANGEBYSCORE', KEYS[1], from, '+inf', 'WITHSCORES', 'LIMIT', count - limit, 1)
+			wait = tonumber(first[2]) + window - now
+		end
+		return {0, (i - 5) / 3, wait}
+	end
+end
+
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {1, -1, 0}
+`)
+
+// RateLimitFilterRedisQuota 基于redis有序集合对手机号做多级滑动窗口限额，比如每分钟1条、每小时5条、每天10条，RedisPool为空时不限制。
+// 每个号码的所有级别在lua脚本中原子检查，全部通过时才占用限额；一个请求的所有号码通过一次pipeline检查。
+// 键为Prefix+KeyFunc的结果，按号码和模板限额时使用PhoneTemplateKey
+type RateLimitFilterRedisQuota struct {
+	seq uint64 // 放在第一个字段保证32位平台上atomic操作时64位对齐
+
+	RedisPool *redis.Pool
+	Prefix    string
+	Tiers     []QuotaTier                     // 有不合法的级别时所有号码都会失败
+	KeyFunc   func(req *SMSReq, i int) string // 键生成函数，为空时使用号码
+}
+
+// NewRateLimitFilterRedisQuota tiers中有Window不到1毫秒或Limit小于0的级别时返回错误
+func NewRateLimitFilterRedisQuota(redisPool *redis.Pool, prefix string, tiers ...QuotaTier) (*RateLimitFilterRedisQuota, error) {
+	if err := validTiers(tiers); err != nil {
+		return nil, err
+	}
+	return &RateLimitFilterRedisQuota{
+		RedisPool: redisPool,
+		Prefix:    prefix,
+		Tiers:     tiers,
+	}, nil
+}
+
+// PhoneTemplateKey 按号码和模板生成限速的键
+func PhoneTemplateKey(req *SMSReq, i int) string {
+	return req.PhoneNumbers[i] + ":" + req.TemplateID
+}
+
+func (rl *RateLimitFilterRedisQuota) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, failed := rl.Filter(ctx, req)
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (rl *RateLimitFilterRedisQuota) Filter(ctx *Context, req *SMSReq) ([]string, []FailReq) {
+	if rl.RedisPool == nil || len(rl.Tiers) == 0 || len(req.PhoneNumbers) == 0 {
+		return req.PhoneNumbers, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+	if err := validTiers(rl.Tiers); err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	keys := make([]string, len(req.PhoneNumbers))
+	for i, pn := range req.PhoneNumbers {
+		keys[i] = pn
+		if rl.KeyFunc != nil {
+			keys[i] = rl.KeyFunc(req, i)
+		}
+	}
+
+	c, err := rl.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+	defer c.Close()
+
+	replies, errs, err := rl.eval(ctx, c, keys, time.Now())
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	var (
+		newNumbers = make([]string, 0, len(req.PhoneNumbers))
+		failed     []FailReq
+	)
+	for i, pn := range req.PhoneNumbers {
+		if errs[i] != nil {
+			failed = append(failed, NewFailReq(pn, errs[i]))
+			continue
+		}
+		if replies[i][0] == 1 {
+			newNumbers = append(newNumbers, pn)
+			continue
+		}
+		f := NewFailReq(pn, ErrExceedLimit)
+		if tier := replies[i][1]; tier >= 0 && tier < len(rl.Tiers) {
+			f.FailReason += ": " + rl.Tiers[tier].String()
+		}
+		f.RetryAfter = time.Duration(replies[i][2]) * time.Millisecond
+		failed = append(failed, f)
+	}
+	return newNumbers, failed
+}
+
+// eval 用一次pipeline对keys执行限额脚本，errs为单个键的错误，err为连接错误
+func (rl *RateLimitFilterRedisQuota) eval(ctx context.Context, c redis.Conn, keys []string, now time.Time) (replies [][]int, errs []error, err error) {
+	var (
+		nowMs     = unixMilli(now)
+		maxWindow int64
+		tiers     = make([]interface{}, 0, 3*len(rl.Tiers))
+	)
+	for _, t := range rl.Tiers {
+		window := int64(t.Window / time.Millisecond)
+		if window > maxWindow {
+			maxWindow = window
+		}
+		tiers = append(tiers, window, t.Limit, "("+strconv.FormatInt(nowMs-window, 10))
+	}
+
+	keysAndArgs := make([][]interface{}, len(keys))
+	for i, key := range keys {
+		args := []interface{}{rl.Prefix + key, nowMs, rl.member(now), maxWindow, nowMs - maxWindow}
+		keysAndArgs[i] = append(args, tiers...)
+	}
+	return evalPipeline(ctx, c, quotaScript, keysAndArgs, 3)
+}
+
+// member 生成有序集合中不重复的成员，同一毫秒内多次发送也分别计数
+func (rl *RateLimitFilterRedisQuota) member(now time.Time) string {
+	seq := atomic.AddUint64(&rl.seq, 1)
+	return strconv.FormatInt(now.UnixNano(), 36) + ":" + strconv.FormatUint(seq, 36) + ":" + strconv.FormatInt(rand.Int63(), 36)
+}
@@ -0,0 +1,115 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitFilterRedisQuota_Filter(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter, err := NewRateLimitFilterRedisQuota(pool, "quota:",
+		QuotaTier{Window: time.Minute, Limit: 1},
+		QuotaTier{Window: time.Hour, Limit: 5})
+	require.NoError(t, err)
+	filter.KeyFunc = PhoneTemplateKey
+	c := pool.Get()
+	c.Do("DEL", "quota:1000:t1", "quota:1000:t2", "quota:1001:t1")
+	c.Close()
+
+	ctx := &Context{}
+	pns, failed := filter.Filter(ctx, &SMSReq{TemplateID: "t1", PhoneNumbers: []string{"1000", "1001", "1000"}})
+	assert.Equal(t, []string{"1000", "1001"}, pns)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "1000", failed[0].PhoneNumber)
+	assert.Equal(t, ReasonRateLimited, failed[0].Reason)
+	assert.Equal(t, "exceed limit: 1 per 1m0s", failed[0].FailReason)
+	assert.True(t, failed[0].RetryAfter > 59*time.Second && failed[0].RetryAfter <= time.Minute)
+
+	// 不同模板分别限额
+	pns, failed = filter.Filter(ctx, &SMSReq{TemplateID: "t2", PhoneNumbers: []string{"1000"}})
+	assert.Equal(t, []string{"1000"}, pns)
+	assert.Empty(t, failed)
+}
+
+func TestRateLimitFilterRedisQuota_InvalidTier(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	for _, tier := range []QuotaTier{{Window: 0, Limit: 1}, {Window: -time.Minute, Limit: 1}, {Window: time.Microsecond, Limit: 1}, {Window: time.Minute, Limit: -1}} {
+		_, err := NewRateLimitFilterRedisQuota(pool, "quota:", QuotaTier{Window: time.Hour, Limit: 5}, tier)
+		assert.Equal(t, ErrInvalidQuotaTier, err, tier.String())
+	}
+
+	// 直接修改Tiers时在Filter中检查
+	filter := &RateLimitFilterRedisQuota{RedisPool: pool, Prefix: "quota:", Tiers: []QuotaTier{{Limit: 1}}}
+	pns, failed := filter.Filter(&Context{}, &SMSReq{PhoneNumbers: []string{"1004", "1005"}})
+	assert.Empty(t, pns)
+	assert.Equal(t, 2, len(failed))
+}
+
+func TestRateLimitFilterRedisQuota_Tiers(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter, err := NewRateLimitFilterRedisQuota(pool, "quota:",
+		QuotaTier{Window: time.Minute, Limit: 1},
+		QuotaTier{Window: time.Hour, Limit: 3})
+	require.NoError(t, err)
+	c := pool.Get()
+	defer c.Close()
+	c.Do("DEL", "quota:1002")
+
+	take := func(d time.Duration) []int {
+		replies, errs, err := filter.eval(context.Background(), c, []string{"1002"}, time.Unix(1000000, 123e6).Add(d))
+		require.NoError(t, err)
+		require.NoError(t, errs[0])
+		return replies[0]
+	}
+	assert.Equal(t, []int{1, -1, 0}, take(0))
+	assert.Equal(t, []int{0, 0, 30000}, take(30*time.Second))
+	assert.Equal(t, []int{1, -1, 0}, take(time.Minute))
+	assert.Equal(t, []int{1, -1, 0}, take(2*time.Minute))
+	// 每小时的限额用完，第一条在一小时后移出窗口
+	assert.Equal(t, []int{0, 1, 57 * 60000}, take(3*time.Minute))
+	// 没有通过的检查不占用限额
+	assert.Equal(t, []int{1, -1, 0}, take(time.Hour))
+	n, err := c.Do("ZCARD", "quota:1002")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestRateLimitFilterRedisQuota_Concurrence(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter, err := NewRateLimitFilterRedisQuota(pool, "quota:",
+		QuotaTier{Window: time.Minute, Limit: 5},
+		QuotaTier{Window: time.Hour, Limit: 10})
+	require.NoError(t, err)
+	c := pool.Get()
+	c.Do("DEL", "quota:1003")
+	c.Close()
+
+	sucCount := int64(0)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				pns, _ := filter.Filter(&Context{}, &SMSReq{PhoneNumbers: []string{"1003"}})
+				atomic.AddInt64(&sucCount, int64(len(pns)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(5), sucCount)
+}
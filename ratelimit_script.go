@@ -0,0 +1,205 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ RateLimiter = (*RateLimitFilterRedisScript)(nil)
+
+// rateLimitScript 令牌桶限速，KEYS[1]为哈希表，保存剩余令牌数tokens(可以是小数)和上次更新的时间last(毫秒)。
+// ARGV: 最大令牌数，放入一个令牌需要的毫秒数(可以是小数，0表示不放入)，当前时间(毫秒)，键过期秒数(0表示不过期)。
+// 令牌按经过的时间连续放入，每秒放入超过1000个令牌时也能正确计算。
+// 返回{是否取得令牌, 下一个令牌放入前需要等待的毫秒数}
+var rateLimitScript = redis.NewScript(1, `
+local max = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local expire = tonumber(ARGV[4])
+
+local v = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(v[1])
+local last = tonumber(v[2])
+if tokens == nil or last == nil then
+	tokens = max
+	last = now
+end
+
+if interval > 0 and now > last then
+	tokens = math.min(max, tokens + (now - last) / interval)
+	last = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+elseif interval > 0 then
+	wait = math.ceil((1 - tokens) * interval)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tokens, 'last', last)
+if expire > 0 then
+	redis.call('EXPIRE', KEYS[1], expire)
+end
+return {allowed, wait}
+`)
+
+// RateLimitFilterRedisScript 基于redis的lua脚本对手机号做令牌桶限速，参数含义和RateLimitFilterRedis相同，RedisPool为空时不限制。
+// 每个号码的检查在脚本中原子执行，不需要WATCH重试，高并发下也可以准确限速；
+// 一个请求的所有号码通过一次pipeline的EVALSHA检查，脚本不在redis缓存中时自动改用EVAL。
+// 当前时间取自本机，多个进程共用时需要保证时钟同步
+type RateLimitFilterRedisScript struct {
+	RedisPool    *redis.Pool
+	MaxTokens    int64
+	Tokens       int64
+	PerSec       int64
+	KeyExpireSec int                             // 键过期秒数，为0时不过期
+	KeyFunc      func(req *SMSReq, i int) string // 键生成函数，为空时使用号码，见NormalizeFilter和E164KeyFunc
+}
+
+// NewRateLimitFilterRedisScript 键过期时间为令牌桶从空到满需要的时间，此时删除键不影响限速结果
+func NewRateLimitFilterRedisScript(redisPool *redis.Pool, maxTokens, tokens int64, per time.Duration) *RateLimitFilterRedisScript {
+	rl := &RateLimitFilterRedisScript{
+		RedisPool: redisPool,
+		MaxTokens: maxTokens,
+		Tokens:    tokens,
+		PerSec:    int64(per.Seconds()),
+	}
+	if d := rl.interval() * time.Duration(maxTokens); d > 0 {
+		rl.KeyExpireSec = int((d + time.Second - 1) / time.Second)
+	}
+	return rl
+}
+
+func (rl *RateLimitFilterRedisScript) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		pns, failed := rl.Filter(ctx, req)
+		req.PhoneNumbers = pns
+		resp.Fail = append(resp.Fail, failed...)
+		return len(pns) == 0
+	}
+}
+
+func (rl *RateLimitFilterRedisScript) Filter(ctx *Context, req *SMSReq) ([]string, []FailReq) {
+	if rl.RedisPool == nil || len(req.PhoneNumbers) == 0 {
+		return req.PhoneNumbers, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	keys := make([]string, len(req.PhoneNumbers))
+	for i, pn := range req.PhoneNumbers {
+		keys[i] = pn
+		if rl.KeyFunc != nil {
+			keys[i] = rl.KeyFunc(req, i)
+		}
+	}
+
+	c, err := rl.RedisPool.GetContext(ctx)
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+	defer c.Close()
+
+	replies, errs, err := rl.eval(ctx, c, keys, time.Now())
+	if err != nil {
+		return nil, appendFailed(nil, req.PhoneNumbers, err)
+	}
+
+	var (
+		newNumbers = make([]string, 0, len(req.PhoneNumbers))
+		failed     []FailReq
+	)
+	for i, pn := range req.PhoneNumbers {
+		if errs[i] != nil {
+			failed = append(failed, NewFailReq(pn, errs[i]))
+			continue
+		}
+		if replies[i][0] == 1 {
+			newNumbers = append(newNumbers, pn)
+			continue
+		}
+		f := NewFailReq(pn, ErrExceedLimit)
+		f.RetryAfter = time.Duration(replies[i][1]) * time.Millisecond
+		failed = append(failed, f)
+	}
+	return newNumbers, failed
+}
+
+// interval 返回放入一个令牌需要的时间
+func (rl *RateLimitFilterRedisScript) interval() time.Duration {
+	if rl.Tokens <= 0 {
+		return 0
+	}
+	return time.Duration(rl.PerSec) * time.Second / time.Duration(rl.Tokens)
+}
+
+// eval 用一次pipeline对keys执行限速脚本，errs为单个键的错误，err为连接错误
+func (rl *RateLimitFilterRedisScript) eval(ctx context.Context, c redis.Conn, keys []string, now time.Time) (replies [][]int, errs []error, err error) {
+	keysAndArgs := make([][]interface{}, len(keys))
+	for i, key := range keys {
+		keysAndArgs[i] = []interface{}{
+			key,
+			rl.MaxTokens,
+			strconv.FormatFloat(float64(rl.interval())/float64(time.Millisecond), 'f', -1, 64),
+			unixMilli(now),
+			rl.KeyExpireSec,
+		}
+	}
+	return evalPipeline(ctx, c, rateLimitScript, keysAndArgs, 2)
+}
+
+// evalPipeline 用一次pipeline对每组keysAndArgs执行EVALSHA，脚本不在缓存中时改用EVAL重新执行。
+// 脚本返回长度为n的整数数组，errs为单次执行的错误，err为连接错误或ctx超时
+func evalPipeline(ctx context.Context, c redis.Conn, script *redis.Script, keysAndArgs [][]interface{}, n int) (replies [][]int, errs []error, err error) {
+	replies = make([][]int, len(keysAndArgs))
+	errs = make([]error, len(keysAndArgs))
+
+	pending := make([]int, len(keysAndArgs))
+	for i := range keysAndArgs {
+		pending[i] = i
+	}
+	for _, send := range []func(redis.Conn, ...interface{}) error{script.SendHash, script.Send} {
+		for _, i := range pending {
+			if err = send(c, keysAndArgs[i]...); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = c.Flush(); err != nil {
+			return nil, nil, err
+		}
+
+		var noScript []int
+		for _, i := range pending {
+			reply, err := redisReceive(ctx, c)
+			if e, ok := err.(redis.Error); ok {
+				// EVAL同时会缓存脚本
+				if strings.HasPrefix(string(e), "NOSCRIPT ") {
+					noScript = append(noScript, i)
+				}
+				errs[i] = err
+				continue
+			}
+			if err != nil {
+				// 不是redis返回的错误，连接已经不可用
+				return nil, nil, err
+			}
+			replies[i], errs[i] = redis.Ints(reply, nil)
+			if errs[i] == nil && len(replies[i]) != n {
+				errs[i] = errors.New("unexpected script reply")
+			}
+		}
+		if len(noScript) == 0 {
+			break
+		}
+		pending = noScript
+	}
+	return replies, errs, nil
+}
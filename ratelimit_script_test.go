@@ -0,0 +1,141 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitFilterRedisScript_Filter(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedisScript(pool, 3, 1, 3*time.Second)
+	assert.Equal(t, 9, filter.KeyExpireSec)
+	ctx := &Context{}
+	c := pool.Get()
+	c.Do("DEL", "script126", "script127")
+	c.Close()
+
+	sucCount := 0
+	for i := 0; i < 5; i++ {
+		pns, failed := filter.Filter(ctx, &SMSReq{PhoneNumbers: []string{"script126"}})
+		if len(failed) == 0 {
+			sucCount++
+			continue
+		}
+		assert.Empty(t, pns)
+		assert.Equal(t, ReasonRateLimited, failed[0].Reason)
+		assert.True(t, failed[0].Retryable)
+		assert.True(t, failed[0].RetryAfter > 0 && failed[0].RetryAfter <= 3*time.Second)
+	}
+	assert.Equal(t, 3, sucCount)
+
+	// 一个请求中的号码一起检查，重复的号码各取一个令牌
+	pns, failed := filter.Filter(ctx, &SMSReq{PhoneNumbers: []string{"script127", "script126", "script127"}})
+	assert.Equal(t, []string{"script127", "script127"}, pns)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "script126", failed[0].PhoneNumber)
+}
+
+func TestRateLimitFilterRedisScript_NoScript(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedisScript(pool, 2, 1, time.Second)
+	c := pool.Get()
+	c.Do("DEL", "script128", "script129")
+	_, err := c.Do("SCRIPT", "FLUSH")
+	require.NoError(t, err)
+	c.Close()
+
+	pns, failed := filter.Filter(&Context{}, &SMSReq{PhoneNumbers: []string{"script128", "script129"}})
+	assert.Empty(t, failed)
+	assert.Equal(t, []string{"script128", "script129"}, pns)
+
+	// EVAL已经缓存了脚本
+	c = pool.Get()
+	defer c.Close()
+	require.NoError(t, rateLimitScript.SendHash(c, "script128", 2, 1000, unixMilli(time.Now()), 2))
+	require.NoError(t, c.Flush())
+	_, err = c.Receive()
+	assert.NoError(t, err)
+}
+
+func TestRateLimitFilterRedisScript_Refill(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedisScript(pool, 1, 1, time.Second)
+	c := pool.Get()
+	defer c.Close()
+	c.Do("DEL", "script130")
+
+	now := time.Now()
+	replies, errs, err := filter.eval(context.Background(), c, []string{"script130", "script130"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, [][]int{{1, 0}, {0, 1000}}, replies)
+
+	replies, _, err = filter.eval(context.Background(), c, []string{"script130", "script130"}, now.Add(1500*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, [][]int{{1, 0}, {0, 1000}}, replies)
+
+	// 每秒2000个令牌，放入一个令牌不到1毫秒
+	filter = NewRateLimitFilterRedisScript(pool, 2, 2000, time.Second)
+	c.Do("DEL", "script132")
+	keys := []string{"script132", "script132", "script132"}
+	replies, _, err = filter.eval(context.Background(), c, keys, now)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int{{1, 0}, {1, 0}, {0, 1}}, replies)
+	replies, _, err = filter.eval(context.Background(), c, keys, now.Add(time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, [][]int{{1, 0}, {1, 0}, {0, 1}}, replies)
+}
+
+func TestRateLimitFilterRedisScript_Concurrence(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedisScript(pool, 3, 3, time.Minute)
+	c := pool.Get()
+	c.Do("DEL", "script131")
+	c.Close()
+
+	sucCount := int64(0)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				pns, _ := filter.Filter(&Context{}, &SMSReq{PhoneNumbers: []string{"script131"}})
+				atomic.AddInt64(&sucCount, int64(len(pns)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(3), sucCount)
+}
+
+func BenchmarkRateLimitFilterRedisScript_Filter(b *testing.B) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	filter := NewRateLimitFilterRedisScript(pool, 1, 1, time.Second)
+	ctx := &Context{}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req := &SMSReq{
+			PhoneNumbers: []string{"125"},
+		}
+		filter.Filter(ctx, req)
+	}
+}
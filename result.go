@@ -0,0 +1,211 @@
+package sms
+
+import (
+	"time"
+)
+
+// 号码的发送状态
+const (
+	StatusUnknown     int32 = 0
+	StatusSent        int32 = 1 // 已提交给短信服务商
+	StatusFailed      int32 = 2 // 被过滤或提交失败
+	StatusScheduled   int32 = 3 // 等待定时发送
+	StatusCanceled    int32 = 4 // 定时发送被取消
+	StatusDelivered   int32 = 5 // 短信服务商回执：已送达
+	StatusUndelivered int32 = 6 // 短信服务商回执：发送失败
+	StatusExpired     int32 = 7 // 短信服务商回执：超过有效期未送达
+)
+
+// Result 单个号码的发送结果
+type Result struct {
+	PhoneNumber string
+	Status      int32
+	MsgID       string // 短信服务商返回的消息ID
+	Sender      string // 处理该号码的Sender名字，没有交给Sender时为空
+	Code        int32  // 成功时为CodeSuccess，失败时为对应的错误码
+}
+
+// Attempt 一次交给Sender发送的记录
+type Attempt struct {
+	Time         time.Time
+	Sender       string
+	PhoneNumbers []string
+	Code         int32
+	Message      string
+	Failed       int // 失败的号码数
+}
+
+// completeResults 补全resp.Results，使resp.Fail和req.PhoneNumbers中的每个号码都有一个结果，
+// 没有发送成功的号码也会补充到resp.Fail中。
+// sender是处理req.PhoneNumbers的Sender名字，为空表示这些号码没有交给Sender。
+// Sender本身没有记录发送过程时，在resp.Attempts中记录这次发送
+func completeResults(req *SMSReq, resp *SMSResp, sender string) {
+	if sender != "" && len(resp.Attempts) == 0 {
+		defer recordAttempt(req, resp, sender)
+	}
+
+	index := make(map[string]int, len(resp.Results)+len(resp.Fail)+len(req.PhoneNumbers))
+	for i, r := range resp.Results {
+		index[r.PhoneNumber] = i
+		if resp.Results[i].Sender == "" {
+			resp.Results[i].Sender = sender
+		}
+	}
+
+	failCode := resp.Code
+	if failCode == CodeSuccess || failCode == CodeSuccessPart {
+		failCode = CodeOther
+	}
+	requested := make(map[string]bool, len(req.PhoneNumbers))
+	for _, pn := range req.PhoneNumbers {
+		requested[pn] = true
+	}
+	handled := requested
+	if sender == "" {
+		handled = nil
+	}
+	for _, f := range resp.Fail {
+		// 同一个请求中重复的号码，保留下来的那个号码的结果以发送为准
+		if f.Reason == ReasonDuplicate && requested[f.PhoneNumber] {
+			continue
+		}
+		if i, ok := index[f.PhoneNumber]; ok {
+			if resp.Results[i].Status != StatusFailed {
+				resp.Results[i].Status = StatusFailed
+				resp.Results[i].Code = failCode
+			}
+			continue
+		}
+		index[f.PhoneNumber] = len(resp.Results)
+		r := Result{
+			PhoneNumber: f.PhoneNumber,
+			Status:      StatusFailed,
+			Code:        failCode,
+		}
+		if handled[f.PhoneNumber] {
+			r.Sender = sender
+		}
+		resp.Results = append(resp.Results, r)
+	}
+
+	sent := sender != "" && (resp.Code == CodeSuccess || resp.Code == CodeSuccessPart)
+	for _, pn := range req.PhoneNumbers {
+		if _, ok := index[pn]; ok {
+			continue
+		}
+		index[pn] = len(resp.Results)
+		r := Result{
+			PhoneNumber: pn,
+			Sender:      sender,
+		}
+		if sent {
+			r.Status = StatusSent
+			r.Code = CodeSuccess
+		} else {
+			r.Status = StatusFailed
+			r.Code = failCode
+			resp.Fail = append(resp.Fail, failReqOfCode(pn, failCode, resp.Message))
+		}
+		resp.Results = append(resp.Results, r)
+	}
+}
+
+// restoreNumbers 把resp.Results和resp.Fail中被过滤器改写过的号码换回请求中原来的号码，
+// 多个号码被改写成同一个号码时，每个原来的号码都有一份相同的结果。resp.Attempts中保留实际发送的号码
+func restoreNumbers(ctx *Context, resp *SMSResp) {
+	if len(ctx.rewritten) == 0 {
+		return
+	}
+	results := make([]Result, 0, len(resp.Results))
+	for _, r := range resp.Results {
+		origs, ok := ctx.rewritten[r.PhoneNumber]
+		if !ok {
+			results = append(results, r)
+			continue
+		}
+		for _, orig := range origs {
+			r.PhoneNumber = orig
+			results = append(results, r)
+		}
+	}
+	resp.Results = results
+
+	var failed []FailReq
+	for _, f := range resp.Fail {
+		origs, ok := ctx.rewritten[f.PhoneNumber]
+		if !ok {
+			failed = append(failed, f)
+			continue
+		}
+		for _, orig := range origs {
+			f.PhoneNumber = orig
+			failed = append(failed, f)
+		}
+	}
+	resp.Fail = failed
+}
+
+func recordAttempt(req *SMSReq, resp *SMSResp, sender string) {
+	numbers := make(map[string]bool, len(req.PhoneNumbers))
+	for _, pn := range req.PhoneNumbers {
+		numbers[pn] = true
+	}
+	failed := 0
+	for _, r := range resp.Results {
+		if r.Status == StatusFailed && numbers[r.PhoneNumber] {
+			failed++
+		}
+	}
+	resp.Attempts = append(resp.Attempts, Attempt{
+		Time:         time.Now(),
+		Sender:       sender,
+		PhoneNumbers: append([]string(nil), req.PhoneNumbers...),
+		Code:         resp.Code,
+		Message:      resp.Message,
+		Failed:       failed,
+	})
+}
+
+// mergeResults 将sub中的结果合并到resp中，同一个号码以sub为准，sub中的发送记录追加到resp中
+func mergeResults(resp *SMSResp, sub *SMSResp) {
+	resp.Attempts = append(resp.Attempts, sub.Attempts...)
+
+	index := make(map[string]int, len(resp.Results))
+	for i, r := range resp.Results {
+		index[r.PhoneNumber] = i
+	}
+	for _, r := range sub.Results {
+		if i, ok := index[r.PhoneNumber]; ok {
+			resp.Results[i] = r
+		} else {
+			index[r.PhoneNumber] = len(resp.Results)
+			resp.Results = append(resp.Results, r)
+		}
+	}
+}
+
+// deriveCode 根据resp.Results设置resp.Code：
+// 全部成功为CodeSuccess，部分成功为CodeSuccessPart，没有号码发送但有号码等待定时发送时为CodeScheduled，全部失败时保留原来的错误码
+func deriveCode(resp *SMSResp) {
+	var sent, failed, scheduled int
+	for _, r := range resp.Results {
+		switch r.Status {
+		case StatusFailed:
+			failed++
+		case StatusScheduled:
+			scheduled++
+		default:
+			sent++
+		}
+	}
+	switch {
+	case sent > 0 && failed == 0:
+		resp.Code = CodeSuccess
+	case sent > 0:
+		resp.Code = CodeSuccessPart
+	case scheduled > 0:
+		resp.Code = CodeScheduled
+	case resp.Code == CodeSuccess || resp.Code == CodeSuccessPart:
+		resp.Code = CodeOther
+	}
+}
@@ -0,0 +1,173 @@
+package sms
+
+import (
+	"github.com/uber-go/zap"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy Sender发送失败时的重试策略，只重试暂时性失败的号码
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多发送几次(包括第一次)，不大于1时不重试
+	InitialBackoff time.Duration // 第一次重试前等待的时间
+	MaxBackoff     time.Duration // 等待时间的上限，0表示不限制
+	Multiplier     float64       // 每次重试等待时间的倍数，不大于1时使用2
+	Jitter         float64       // 等待时间随机浮动的比例，0~1
+	Reasons        []ReasonCode  // 只重试这些原因的失败
+	Codes          []int32       // 只重试号码结果(Result.Code)是这些错误码的失败，和Reasons都为空时根据FailReq.Retryable判断
+}
+
+func NewRetryPolicy(maxAttempts int, initialBackoff, maxBackoff time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Retryable 判断f是否需要重试，code是该号码这次发送结果的错误码。
+// 设置了Reasons或Codes时，原因或错误码满足其中之一就重试
+func (rp *RetryPolicy) Retryable(f FailReq, code int32) bool {
+	if len(rp.Reasons) == 0 && len(rp.Codes) == 0 {
+		return f.Retryable
+	}
+	for _, r := range rp.Reasons {
+		if r == f.Reason {
+			return true
+		}
+	}
+	for _, c := range rp.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff 返回第attempt次发送失败后需要等待的时间，attempt从1开始
+func (rp *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	d := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && d > float64(rp.MaxBackoff) {
+		d = float64(rp.MaxBackoff)
+	}
+	if rp.Jitter > 0 {
+		d += (rand.Float64()*2 - 1) * rp.Jitter * d
+	}
+	return time.Duration(d)
+}
+
+// withRetry 给s加上重试。s带熔断时在熔断里面重试，所有重试结束后只记录一次结果
+func withRetry(s Sender, policy *RetryPolicy) Sender {
+	if bs, ok := s.(*breakerSender); ok {
+		return &breakerSender{
+			breaker:  bs.breaker,
+			sender:   &retrySender{sender: bs.sender, policy: policy},
+			selector: bs.selector,
+		}
+	}
+	return &retrySender{sender: s, policy: policy}
+}
+
+// retrySender 按RetryPolicy重试Sender中暂时性失败的号码
+type retrySender struct {
+	sender Sender
+	policy *RetryPolicy
+}
+
+func (rs *retrySender) Name() string {
+	return SenderName(rs.sender)
+}
+
+func (rs *retrySender) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
+	var (
+		name      = rs.Name()
+		pending   = req.PhoneNumbers
+		failed    []FailReq
+		succeeded int
+		last      SMSResp
+	)
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if err := ctx.Err(); err != nil {
+			// 被取消时还没有发送的号码按超时失败
+			failed = appendFailed(failed, pending, err)
+			last = SMSResp{Code: CodeTimeout, Message: err.Error()}
+			break
+		}
+
+		subReq := *req
+		subReq.PhoneNumbers = pending
+		last = SMSResp{ID: resp.ID}
+		rs.sender.Send(ctx, &subReq, &last)
+		completeResults(&subReq, &last, resultSender(rs.sender))
+		mergeResults(resp, &last)
+
+		var retry []FailReq
+		lastFailed := failedNumbers(&subReq, &last)
+		succeeded += len(pending) - len(lastFailed)
+		codes := make(map[string]int32, len(last.Results))
+		for _, r := range last.Results {
+			codes[r.PhoneNumber] = r.Code
+		}
+		for _, f := range lastFailed {
+			if rs.policy.Retryable(f, codes[f.PhoneNumber]) {
+				retry = append(retry, f)
+			} else {
+				failed = append(failed, f)
+			}
+		}
+
+		ctx.Logger.Info(
+			"send attempt",
+			zap.String("id", resp.ID),
+			zap.String("sender", name),
+			zap.Int("attempt", attempt),
+			zap.Int("numbers", len(pending)),
+			zap.Int("failed", len(lastFailed)),
+			zap.Int("retryable", len(retry)),
+			zap.Int64("code", int64(last.Code)),
+			zap.String("message", last.Message),
+		)
+
+		if len(retry) == 0 || attempt >= rs.policy.MaxAttempts || !rs.wait(ctx, attempt, retry) {
+			failed = append(failed, retry...)
+			break
+		}
+		pending = make([]string, len(retry))
+		for i, f := range retry {
+			pending[i] = f.PhoneNumber
+		}
+	}
+
+	resp.Fail = append(resp.Fail, failed...)
+	setMergedCode(resp, succeeded, len(failed), &last)
+}
+
+// wait 等待第attempt次重试，失败中建议的重试间隔比退避时间长时使用建议的间隔(不超过MaxBackoff)。
+// ctx结束时返回false
+func (rs *retrySender) wait(ctx *Context, attempt int, retry []FailReq) bool {
+	d := rs.policy.Backoff(attempt)
+	for _, f := range retry {
+		if f.RetryAfter > d {
+			d = f.RetryAfter
+		}
+	}
+	if rs.policy.MaxBackoff > 0 && d > rs.policy.MaxBackoff {
+		d = rs.policy.MaxBackoff
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	rp := NewRetryPolicy(5, 100*time.Millisecond, time.Second)
+	rp.Jitter = 0
+	assert.Equal(t, 100*time.Millisecond, rp.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, rp.Backoff(2))
+	assert.Equal(t, 400*time.Millisecond, rp.Backoff(3))
+	assert.Equal(t, time.Second, rp.Backoff(5))
+
+	rp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := rp.Backoff(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond, d.String())
+	}
+}
+
+func TestSend_Retry(t *testing.T) {
+	req := getTestReq()
+	var attempts [][]string
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, NameSender("flaky", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		attempts = append(attempts, append([]string(nil), req.PhoneNumbers...))
+		for _, pn := range req.PhoneNumbers {
+			switch {
+			case pn == "1000001" && len(attempts) < 3:
+				resp.Fail = append(resp.Fail, FailReq{PhoneNumber: pn, FailReason: "busy", Reason: ReasonProviderError, Retryable: true})
+			case pn == "1000002":
+				resp.Fail = append(resp.Fail, FailReq{PhoneNumber: pn, FailReason: "bad number", Reason: ReasonInvalidNumber})
+			}
+		}
+		resp.Code = CodeSuccessPart
+	})))
+
+	logger := zap.NewJSON()
+	logger.SetLevel(zap.ErrorLevel)
+	resp := Send(&Context{
+		Selector: selector,
+		Logger:   logger,
+		Retry:    NewRetryPolicy(3, time.Millisecond, 10*time.Millisecond),
+	}, req)
+
+	assert.Equal(t, [][]string{
+		{"1000000", "1000001", "1000002"},
+		{"1000001"},
+		{"1000001"},
+	}, attempts)
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, "1000002", resp.Fail[0].PhoneNumber)
+
+	sort.Slice(resp.Results, func(i, j int) bool { return resp.Results[i].PhoneNumber < resp.Results[j].PhoneNumber })
+	assert.Equal(t, []Result{
+		{PhoneNumber: "1000000", Status: StatusSent, Sender: "flaky", Code: CodeSuccess},
+		{PhoneNumber: "1000001", Status: StatusSent, Sender: "flaky", Code: CodeSuccess},
+		{PhoneNumber: "1000002", Status: StatusFailed, Sender: "flaky", Code: CodeOther},
+	}, resp.Results)
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	rp := NewRetryPolicy(3, time.Millisecond, time.Millisecond)
+	busy := FailReq{PhoneNumber: "1000000", Reason: ReasonProviderError, Retryable: true}
+	bad := FailReq{PhoneNumber: "1000000", Reason: ReasonInvalidNumber}
+	assert.True(t, rp.Retryable(busy, CodeOther))
+	assert.False(t, rp.Retryable(bad, CodeOther))
+
+	rp.Codes = []int32{CodeTimeout}
+	assert.False(t, rp.Retryable(busy, CodeOther))
+	assert.True(t, rp.Retryable(bad, CodeTimeout))
+
+	rp.Reasons = []ReasonCode{ReasonProviderError}
+	assert.True(t, rp.Retryable(busy, CodeOther))
+	assert.False(t, rp.Retryable(bad, CodeOther))
+}
+
+func TestSend_RetryCanceled(t *testing.T) {
+	req := getTestReq()
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := &Context{Context: c}
+	ctx.setDefaults()
+
+	sender := withRetry(NameSender("flaky", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		t.Error("canceled request should not be sent")
+	})), NewRetryPolicy(3, time.Millisecond, time.Millisecond))
+	resp := &SMSResp{}
+	sender.Send(ctx, req, resp)
+
+	assert.Equal(t, CodeTimeout, resp.Code)
+	require.Equal(t, 3, len(resp.Fail))
+	for _, f := range resp.Fail {
+		assert.Equal(t, ReasonTimeout, f.Reason)
+	}
+	completeResults(req, resp, resultSender(sender))
+	for _, r := range resp.Results {
+		assert.Equal(t, StatusFailed, r.Status)
+	}
+}
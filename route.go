@@ -0,0 +1,170 @@
+package sms
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 国内各运营商的号段
+var (
+	ChinaMobilePrefixes = []string{
+		"134", "135", "136", "137", "138", "139", "147", "148", "150", "151", "152", "157", "158", "159",
+		"172", "178", "182", "183", "184", "187", "188", "195", "197", "198",
+	}
+	ChinaUnicomPrefixes = []string{
+		"130", "131", "132", "140", "145", "146", "155", "156", "166", "167", "171", "175", "176", "185", "186", "196",
+	}
+	ChinaTelecomPrefixes = []string{
+		"133", "141", "149", "153", "162", "173", "174", "177", "180", "181", "189", "190", "191", "193", "199",
+	}
+)
+
+// InternationalRegexp 匹配以+或00开头、国家码不是86的国际号码
+var InternationalRegexp = regexp.MustCompile(`^(\+|00)([0-79]|8[0-57-9])`)
+
+// RouteRule 号码路由规则。
+// 号码以Prefixes中的任意一个开头，或者匹配Regexp时交给Sender发送；
+// Prefixes和Regexp都为空时匹配所有号码。
+// 带有+86、0086或86国家码的号码会去掉国家码再和Prefixes比较
+type RouteRule struct {
+	Name     string
+	Prefixes []string
+	Regexp   *regexp.Regexp
+	Sender   Sender
+}
+
+func (r *RouteRule) Match(phoneNumber string) bool {
+	if len(r.Prefixes) == 0 && r.Regexp == nil {
+		return true
+	}
+	national := trimChinaCode(phoneNumber)
+	for _, p := range r.Prefixes {
+		if strings.HasPrefix(phoneNumber, p) || strings.HasPrefix(national, p) {
+			return true
+		}
+	}
+	return r.Regexp != nil && r.Regexp.MatchString(phoneNumber)
+}
+
+func trimChinaCode(phoneNumber string) string {
+	switch {
+	case strings.HasPrefix(phoneNumber, "+86"):
+		return phoneNumber[3:]
+	case strings.HasPrefix(phoneNumber, "0086"):
+		return phoneNumber[4:]
+	case strings.HasPrefix(phoneNumber, "86") && len(phoneNumber) == 13:
+		return phoneNumber[2:]
+	}
+	return phoneNumber
+}
+
+// RouteSelector 根据号码把一次请求拆成多组，每组交给匹配的规则中的Sender发送，
+// 各组的结果合并到同一个SMSResp中。规则按添加的顺序匹配，没有匹配任何规则的号码发送失败
+type RouteSelector struct {
+	rules map[string][]*RouteRule
+	sync.RWMutex
+}
+
+func (rs *RouteSelector) AddRule(category string, rule *RouteRule) {
+	rs.Lock()
+	if rs.rules == nil {
+		rs.rules = make(map[string][]*RouteRule)
+	}
+	rs.rules[category] = append(rs.rules[category], rule)
+	rs.Unlock()
+}
+
+func (rs *RouteSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
+	rs.RLock()
+	rules := rs.rules[req.Category]
+	rs.RUnlock()
+	if len(rules) == 0 {
+		errCode = CodeNoSender
+		err = errors.New("no sender under " + req.Category)
+		return
+	}
+
+	route := &routeSender{}
+	groups := make(map[*RouteRule]int, len(rules))
+LOOP:
+	for _, pn := range req.PhoneNumbers {
+		for _, rule := range rules {
+			if !rule.Match(pn) {
+				continue
+			}
+			i, ok := groups[rule]
+			if !ok {
+				i = len(route.groups)
+				groups[rule] = i
+				route.groups = append(route.groups, routeGroup{rule: rule})
+			}
+			route.groups[i].phoneNumbers = append(route.groups[i].phoneNumbers, pn)
+			continue LOOP
+		}
+		route.unrouted = append(route.unrouted, pn)
+	}
+	sender = route
+	return
+}
+
+type routeGroup struct {
+	rule         *RouteRule
+	phoneNumbers []string
+}
+
+// routeSender 将每组号码交给对应的Sender发送并合并结果
+type routeSender struct {
+	groups   []routeGroup
+	unrouted []string
+}
+
+func (rs *routeSender) Name() string {
+	return "route"
+}
+
+func (rs *routeSender) dispatch() {}
+
+func (rs *routeSender) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
+	var (
+		failed    = appendFailed(nil, rs.unrouted, ErrNoRoute)
+		succeeded int
+		last      = SMSResp{Code: CodeNoSender, Message: "no route"}
+	)
+	for _, pn := range rs.unrouted {
+		resp.Results = append(resp.Results, Result{
+			PhoneNumber: pn,
+			Status:      StatusFailed,
+			Code:        CodeNoSender,
+		})
+	}
+	for _, g := range rs.groups {
+		if err := ctx.Err(); err != nil {
+			failed = appendFailed(failed, g.phoneNumbers, err)
+			last = SMSResp{Code: CodeTimeout, Message: err.Error()}
+			continue
+		}
+
+		subReq := *req
+		subReq.PhoneNumbers = g.phoneNumbers
+		subResp := SMSResp{ID: resp.ID}
+		g.rule.Sender.Send(ctx, &subReq, &subResp)
+		completeResults(&subReq, &subResp, SenderName(g.rule.Sender))
+		mergeResults(resp, &subResp)
+
+		f := failedNumbers(&subReq, &subResp)
+		succeeded += len(g.phoneNumbers) - len(f)
+		if len(f) > 0 {
+			failed = append(failed, f...)
+			last = subResp
+		}
+	}
+
+	resp.Fail = append(resp.Fail, failed...)
+	setMergedCode(resp, succeeded, len(failed), &last)
+}
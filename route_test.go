@@ -0,0 +1,120 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+func TestRouteRule_Match(t *testing.T) {
+	mobile := &RouteRule{Prefixes: ChinaMobilePrefixes}
+	intl := &RouteRule{Regexp: InternationalRegexp}
+	all := &RouteRule{}
+
+	cases := []struct {
+		phoneNumber  string
+		mobile, intl bool
+	}{
+		{"13800000000", true, false},
+		{"+8613800000000", true, false},
+		{"008613800000000", true, false},
+		{"8613800000000", true, false},
+		{"13000000000", false, false},
+		{"+14155550100", false, true},
+		{"00447700900000", false, true},
+		{"+85291234567", false, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.mobile, mobile.Match(c.phoneNumber), c.phoneNumber)
+		assert.Equal(t, c.intl, intl.Match(c.phoneNumber), c.phoneNumber)
+		assert.True(t, all.Match(c.phoneNumber), c.phoneNumber)
+	}
+}
+
+func TestRouteSelector(t *testing.T) {
+	received := make(map[string][]string)
+	recorder := func(name string, code int32) Sender {
+		return NameSender(name, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+			received[name] = append(received[name], req.PhoneNumbers...)
+			resp.Code = code
+			if code != CodeSuccess {
+				resp.Message = name + " down"
+			}
+		}))
+	}
+
+	selector := &RouteSelector{}
+	selector.AddRule("test", &RouteRule{Name: "mobile", Prefixes: ChinaMobilePrefixes, Sender: recorder("mobile", CodeSuccess)})
+	selector.AddRule("test", &RouteRule{Name: "unicom", Prefixes: ChinaUnicomPrefixes, Sender: recorder("unicom", CodeSuccess)})
+	selector.AddRule("test", &RouteRule{Name: "intl", Regexp: InternationalRegexp, Sender: recorder("intl", CodeOther)})
+
+	req := &SMSReq{
+		Category:     "test",
+		PhoneNumbers: []string{"13800000000", "13000000000", "+14155550100", "13900000000", "12345"},
+	}
+	resp := Send(&Context{Selector: selector}, req)
+
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	assert.Equal(t, []string{"13800000000", "13900000000"}, received["mobile"])
+	assert.Equal(t, []string{"13000000000"}, received["unicom"])
+	assert.Equal(t, []string{"+14155550100"}, received["intl"])
+
+	require.Equal(t, 2, len(resp.Fail))
+	sort.Slice(resp.Fail, func(i, j int) bool { return resp.Fail[i].PhoneNumber < resp.Fail[j].PhoneNumber })
+	assert.Equal(t, FailReq{PhoneNumber: "+14155550100", FailReason: "intl down", Reason: ReasonProviderError, Retryable: true}, resp.Fail[0])
+	assert.Equal(t, FailReq{PhoneNumber: "12345", FailReason: "no route", Reason: ReasonNoSender}, resp.Fail[1])
+	results := make(map[string]Result)
+	for _, r := range resp.Results {
+		results[r.PhoneNumber] = r
+	}
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, Result{PhoneNumber: "13900000000", Status: StatusSent, Sender: "mobile", Code: CodeSuccess}, results["13900000000"])
+	assert.Equal(t, Result{PhoneNumber: "+14155550100", Status: StatusFailed, Sender: "intl", Code: CodeOther}, results["+14155550100"])
+	assert.Equal(t, Result{PhoneNumber: "12345", Status: StatusFailed, Code: CodeNoSender}, results["12345"])
+}
+
+func TestRouteSelector_Canceled(t *testing.T) {
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	selector := &RouteSelector{}
+	selector.AddRule("test", &RouteRule{Name: "mobile", Prefixes: ChinaMobilePrefixes, Sender: NameSender("mobile", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+		cancel()
+	}))})
+	selector.AddRule("test", &RouteRule{Name: "unicom", Prefixes: ChinaUnicomPrefixes, Sender: NameSender("unicom", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		t.Error("canceled group should not be sent")
+	}))})
+
+	req := &SMSReq{
+		Category:     "test",
+		PhoneNumbers: []string{"13800000000", "13000000000"},
+	}
+	ctx := &Context{Selector: selector}
+	ctx.setDefaults()
+	ctx.Context = c
+	sender, _, err := selector.Select(ctx, req)
+	require.NoError(t, err)
+	resp := &SMSResp{}
+	sender.Send(ctx, req, resp)
+	completeResults(req, resp, resultSender(sender))
+
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, "13000000000", resp.Fail[0].PhoneNumber)
+	assert.Equal(t, ReasonTimeout, resp.Fail[0].Reason)
+	sort.Slice(resp.Results, func(i, j int) bool { return resp.Results[i].PhoneNumber < resp.Results[j].PhoneNumber })
+	assert.Equal(t, []Result{
+		{PhoneNumber: "13000000000", Status: StatusFailed, Code: CodeOther},
+		{PhoneNumber: "13800000000", Status: StatusSent, Sender: "mobile", Code: CodeSuccess},
+	}, resp.Results)
+
+	// 全部被取消时返回超时
+	resp = &SMSResp{}
+	sender, _, err = selector.Select(&Context{}, &SMSReq{Category: "test", PhoneNumbers: []string{"13000000000"}})
+	require.NoError(t, err)
+	sender.Send(ctx, &SMSReq{Category: "test", PhoneNumbers: []string{"13000000000"}}, resp)
+	assert.Equal(t, CodeTimeout, resp.Code)
+}
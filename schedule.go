@@ -0,0 +1,366 @@
+package sms
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+	"sync"
+	"time"
+)
+
+// ScheduledReq 等待定时发送的请求
+type ScheduledReq struct {
+	ID     string
+	Req    *SMSReq
+	At     time.Time
+	Parent string // 不为空时以该ID发送，结果更新到原来的消息中，比如免打扰时段延后发送的号码
+}
+
+// ScheduleStore 保存定时发送的请求。
+// Due取出并删除At不晚于now的请求，多个进程共用同一个存储时每个请求只会被取出一次，
+// 返回错误时也会返回已经取出的请求
+type ScheduleStore interface {
+	Add(sr *ScheduledReq) error
+	Remove(id string) (removed bool, err error)
+	Due(now time.Time, limit int) ([]*ScheduledReq, error)
+}
+
+// Scheduler 保存设置了SendAt或Delay的请求，到时间后交给正常的发送流程
+type Scheduler struct {
+	Store    ScheduleStore
+	Interval time.Duration // 检查到期请求的间隔
+	Batch    int           // 每次最多取出多少个到期的请求
+	Async    *AsyncSender  // 不为空时交给Async发送，否则在Run中依次发送
+	OnResult func(req *SMSReq, resp *SMSResp)
+
+	ctx *Context
+}
+
+func NewScheduler(ctx *Context, store ScheduleStore) *Scheduler {
+	if ctx == nil {
+		ctx = &Context{}
+	}
+	ctx.setDefaults()
+	return &Scheduler{
+		Store:    store,
+		Interval: time.Second,
+		Batch:    100,
+		ctx:      ctx,
+	}
+}
+
+// SendTime 返回req应该发送的时间，没有设置SendAt和Delay时返回零值
+func SendTime(req *SMSReq, now time.Time) time.Time {
+	if !req.SendAt.IsZero() {
+		return req.SendAt
+	}
+	if req.Delay > 0 {
+		return now.Add(req.Delay)
+	}
+	return time.Time{}
+}
+
+// Schedule 保存req，到SendTime(req)时发送，返回的id可以用于Cancel和查询发送结果
+func (s *Scheduler) Schedule(req *SMSReq) (id string, err error) {
+	resp, err := s.schedule(req)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// schedule 保存req，返回Code为CodeScheduled的结果。设置了MessageStore时同时保存消息状态
+func (s *Scheduler) schedule(req *SMSReq) (*SMSResp, error) {
+	at := SendTime(req, time.Now())
+	if at.IsZero() {
+		return nil, errors.New("neither SendAt nor Delay is set")
+	}
+	sr := &ScheduledReq{
+		ID:  s.ctx.IDGen.Next(),
+		Req: copyReq(req),
+		At:  at,
+	}
+	if err := s.Store.Add(sr); err != nil {
+		return nil, err
+	}
+
+	resp := &SMSResp{
+		ID:      sr.ID,
+		Code:    CodeScheduled,
+		Message: "scheduled at " + at.Format(time.RFC3339),
+	}
+	for _, pn := range req.PhoneNumbers {
+		resp.Results = append(resp.Results, Result{
+			PhoneNumber: pn,
+			Status:      StatusScheduled,
+		})
+	}
+	if s.ctx.Messages != nil {
+		saveMessage(s.ctx, req, resp)
+	}
+	return resp, nil
+}
+
+// Send 设置了SendAt或Delay时保存req并返回CodeScheduled，否则在c的控制下立即发送
+func (s *Scheduler) Send(c context.Context, req *SMSReq) *SMSResp {
+	if SendTime(req, time.Now()).IsZero() {
+		return sendWithID(c, s.ctx, req, "")
+	}
+	resp, err := s.schedule(req)
+	if err != nil {
+		s.ctx.Logger.Error("cann't schedule sms", zap.Error(err))
+		resp = &SMSResp{
+			Code:    CodeOther,
+			Message: err.Error(),
+			Fail:    appendFailed(nil, req.PhoneNumbers, err),
+		}
+		completeResults(req, resp, "")
+	}
+	return resp
+}
+
+// Cancel 取消还没有发送的请求，请求不存在或已经发送时返回false。
+// 设置了MessageStore时将消息状态改为取消，并通过Webhook回调
+func (s *Scheduler) Cancel(id string) (bool, error) {
+	removed, err := s.Store.Remove(id)
+	if err != nil || !removed || s.ctx.Messages == nil {
+		return removed, err
+	}
+	var canceled *Message
+	now := time.Now()
+	err = s.ctx.Messages.Update(id, func(m *Message) error {
+		canceled = m
+		m.Code = CodeCanceled
+		m.UpdatedAt = now
+		for i := range m.Recipients {
+			m.Recipients[i].Status = StatusCanceled
+			m.Recipients[i].Code = CodeCanceled
+			m.Recipients[i].UpdatedAt = now
+		}
+		return nil
+	})
+	if err == nil && s.ctx.Webhook != nil {
+		s.ctx.Webhook.Notify(canceled)
+	} else if err != nil && err != ErrNotFound {
+		s.ctx.Logger.Error("cann't update canceled message", zap.String("id", id), zap.Error(err))
+	}
+	return true, nil
+}
+
+// Run 定时取出到期的请求发送，直到c结束
+func (s *Scheduler) Run(c context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.release(c)
+		select {
+		case <-ticker.C:
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// release 发送到期的请求，c结束时没有发送的请求和交给Async失败的请求放回Store
+func (s *Scheduler) release(c context.Context) {
+	for c.Err() == nil {
+		due, err := s.Store.Due(time.Now(), s.Batch)
+		if err != nil {
+			s.ctx.Logger.Error("cann't get scheduled sms", zap.Error(err))
+		}
+		for i, sr := range due {
+			if c.Err() != nil {
+				s.restore(due[i:]...)
+				return
+			}
+			req := copyReq(sr.Req)
+			req.SendAt = time.Time{}
+			req.Delay = 0
+			id := sr.ID
+			if sr.Parent != "" {
+				id = sr.Parent
+			}
+			if s.Async != nil {
+				if _, err := s.Async.enqueueWithID(c, id, req); err != nil {
+					s.ctx.Logger.Error("cann't enqueue scheduled sms", zap.String("id", sr.ID), zap.Error(err))
+					s.restore(sr)
+				}
+				continue
+			}
+			resp := sendWithID(c, s.ctx, req, id)
+			if s.OnResult != nil {
+				s.OnResult(req, resp)
+			}
+		}
+		if err != nil || len(due) < s.Batch {
+			return
+		}
+	}
+}
+
+// restore 将已经取出但没有发送的请求放回Store，按原来的时间在下次检查时发送
+func (s *Scheduler) restore(due ...*ScheduledReq) {
+	for _, sr := range due {
+		if err := s.Store.Add(sr); err != nil {
+			s.ctx.Logger.Error("cann't restore scheduled sms", zap.String("id", sr.ID), zap.Error(err))
+		}
+	}
+}
+
+// MemoryScheduleStore 保存在内存中的ScheduleStore，进程退出后请求会丢失
+type MemoryScheduleStore struct {
+	queue scheduleHeap
+	index map[string]*ScheduledReq
+	sync.Mutex
+}
+
+func (ms *MemoryScheduleStore) Add(sr *ScheduledReq) error {
+	ms.Lock()
+	if ms.index == nil {
+		ms.index = make(map[string]*ScheduledReq)
+	}
+	ms.index[sr.ID] = sr
+	heap.Push(&ms.queue, sr)
+	ms.Unlock()
+	return nil
+}
+
+func (ms *MemoryScheduleStore) Remove(id string) (bool, error) {
+	ms.Lock()
+	_, ok := ms.index[id]
+	// 堆中的元素在Due时跳过
+	delete(ms.index, id)
+	ms.Unlock()
+	return ok, nil
+}
+
+func (ms *MemoryScheduleStore) Due(now time.Time, limit int) ([]*ScheduledReq, error) {
+	var due []*ScheduledReq
+	ms.Lock()
+	for len(ms.queue) > 0 && len(due) < limit && !ms.queue[0].At.After(now) {
+		sr := heap.Pop(&ms.queue).(*ScheduledReq)
+		if ms.index[sr.ID] != sr {
+			continue
+		}
+		delete(ms.index, sr.ID)
+		due = append(due, sr)
+	}
+	ms.Unlock()
+	return due, nil
+}
+
+type scheduleHeap []*ScheduledReq
+
+func (h scheduleHeap) Len() int            { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool  { return h[i].At.Before(h[j].At) }
+func (h scheduleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap) Push(x interface{}) { *h = append(*h, x.(*ScheduledReq)) }
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// dueScript 原子的取出并删除KEYS[1]中分数不大于ARGV[1]的最多ARGV[2]个请求，返回{id, data, id, data...}
+var dueScript = redis.NewScript(2, `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local due = {}
+for _, id in ipairs(ids) do
+	local data = redis.call('HGET', KEYS[2], id)
+	redis.call('ZREM', KEYS[1], id)
+	if data then
+		redis.call('HDEL', KEYS[2], id)
+		due[#due + 1] = id
+		due[#due + 1] = data
+	end
+end
+return due
+`)
+
+// RedisScheduleStore 保存在redis中的ScheduleStore。
+// 请求以JSON格式保存在Prefix+"reqs"哈希表中，Prefix+"queue"有序集合以发送时间(毫秒)排序，
+// 无法解析的请求移到Prefix+"invalid"哈希表中
+type RedisScheduleStore struct {
+	RedisPool *redis.Pool
+	Prefix    string
+}
+
+func NewRedisScheduleStore(redisPool *redis.Pool, prefix string) *RedisScheduleStore {
+	return &RedisScheduleStore{
+		RedisPool: redisPool,
+		Prefix:    prefix,
+	}
+}
+
+func (rs *RedisScheduleStore) reqsKey() string {
+	return rs.Prefix + "reqs"
+}
+
+func (rs *RedisScheduleStore) queueKey() string {
+	return rs.Prefix + "queue"
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (rs *RedisScheduleStore) Add(sr *ScheduledReq) error {
+	data, err := json.Marshal(sr)
+	if err != nil {
+		return err
+	}
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("HSET", rs.reqsKey(), sr.ID, data)
+	c.Send("ZADD", rs.queueKey(), unixMilli(sr.At), sr.ID)
+	_, err = c.Do("EXEC")
+	return err
+}
+
+func (rs *RedisScheduleStore) Remove(id string) (bool, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("ZREM", rs.queueKey(), id)
+	c.Send("HDEL", rs.reqsKey(), id)
+	replies, err := redis.Ints(c.Do("EXEC"))
+	if err != nil {
+		return false, err
+	}
+	return replies[0] > 0, nil
+}
+
+func (rs *RedisScheduleStore) Due(now time.Time, limit int) ([]*ScheduledReq, error) {
+	c := rs.RedisPool.Get()
+	defer c.Close()
+
+	// 在脚本中同时取出和删除，不会出现已经从队列中删除但没有读到请求的情况
+	reply, err := redis.Strings(dueScript.Do(c, rs.queueKey(), rs.reqsKey(), unixMilli(now), limit))
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]*ScheduledReq, 0, len(reply)/2)
+	for i := 0; i+1 < len(reply); i += 2 {
+		id, data := reply[i], reply[i+1]
+		sr := &ScheduledReq{}
+		if e := json.Unmarshal([]byte(data), sr); e != nil {
+			err = errors.New("invalid scheduled sms " + id + ": " + e.Error())
+			if _, e = c.Do("HSET", rs.Prefix+"invalid", id, data); e != nil {
+				err = errors.New(err.Error() + ", cann't save it: " + e.Error())
+			}
+			continue
+		}
+		due = append(due, sr)
+	}
+	return due, err
+}
@@ -0,0 +1,183 @@
+package sms
+
+import (
+	"context"
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testScheduleStore(t *testing.T, store ScheduleStore) {
+	now := time.Now()
+	for i := 4; i >= 0; i-- {
+		err := store.Add(&ScheduledReq{
+			ID:  strconv.Itoa(i),
+			Req: getTestReq(),
+			At:  now.Add(time.Duration(i) * time.Second),
+		})
+		require.NoError(t, err)
+	}
+
+	removed, err := store.Remove("1")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = store.Remove("100")
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	due, err := store.Due(now.Add(3*time.Second), 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(due))
+	assert.Equal(t, "0", due[0].ID)
+	assert.Equal(t, "2", due[1].ID)
+	assert.Equal(t, getTestReq(), due[0].Req)
+
+	due, err = store.Due(now.Add(3*time.Second), 2)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(due))
+	assert.Equal(t, "3", due[0].ID)
+
+	// 已经取出的请求不能再取消
+	removed, err = store.Remove("3")
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	due, err = store.Due(now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(due))
+	assert.Equal(t, "4", due[0].ID)
+}
+
+func TestMemoryScheduleStore(t *testing.T) {
+	testScheduleStore(t, &MemoryScheduleStore{})
+}
+
+func TestRedisScheduleStore(t *testing.T) {
+	pool := buildTestRedisPool()
+	defer pool.Close()
+
+	prefix := "schedule:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	store := NewRedisScheduleStore(pool, prefix)
+	testScheduleStore(t, store)
+
+	// 无法解析的请求移到invalid中，不影响其他请求
+	now := time.Now()
+	require.NoError(t, store.Add(&ScheduledReq{ID: "valid", Req: getTestReq(), At: now}))
+	c := pool.Get()
+	defer c.Close()
+	c.Do("HSET", prefix+"reqs", "invalid", "{")
+	c.Do("ZADD", prefix+"queue", unixMilli(now), "invalid")
+	due, err := store.Due(now, 10)
+	assert.Error(t, err)
+	require.Equal(t, 1, len(due))
+	assert.Equal(t, "valid", due[0].ID)
+	data, err := redis.String(c.Do("HGET", prefix+"invalid", "invalid"))
+	require.NoError(t, err)
+	assert.Equal(t, "{", data)
+	n, err := redis.Int(c.Do("ZCARD", prefix+"queue"))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestScheduler(t *testing.T) {
+	req := getTestReq()
+	sent := make(chan *SMSReq, 2)
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+		sent <- req
+	}))
+	ctx := &Context{
+		Selector: selector,
+		Logger:   zap.NewJSON(),
+	}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+
+	s := NewScheduler(ctx, &MemoryScheduleStore{})
+	s.Interval = 10 * time.Millisecond
+	ids := make(chan string, 2)
+	s.OnResult = func(req *SMSReq, resp *SMSResp) {
+		assert.Equal(t, CodeSuccess, resp.Code)
+		ids <- resp.ID
+	}
+
+	req.Delay = 20 * time.Millisecond
+	resp := s.Send(context.Background(), req)
+	assert.Equal(t, CodeScheduled, resp.Code)
+	require.Equal(t, len(req.PhoneNumbers), len(resp.Results))
+	assert.Equal(t, StatusScheduled, resp.Results[0].Status)
+
+	canceled, err := s.Schedule(req)
+	require.NoError(t, err)
+	ok, err := s.Cancel(canceled)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(c)
+
+	select {
+	case id := <-ids:
+		assert.Equal(t, resp.ID, id)
+	case <-time.After(time.Second):
+		t.Fatal("scheduled sms not sent")
+	}
+	r := <-sent
+	assert.Zero(t, r.Delay)
+
+	select {
+	case id := <-ids:
+		t.Fatal("canceled sms sent: " + id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 没有设置发送时间时立即发送
+	req.Delay = 0
+	resp = s.Send(context.Background(), req)
+	assert.Equal(t, CodeSuccess, resp.Code)
+}
+
+func TestScheduler_Restore(t *testing.T) {
+	req := getTestReq()
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var sent int
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+		sent++
+		cancel()
+	}))
+	ctx := &Context{Selector: selector, Logger: zap.NewJSON()}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+
+	store := &MemoryScheduleStore{}
+	s := NewScheduler(ctx, store)
+	now := time.Now()
+	require.NoError(t, store.Add(&ScheduledReq{ID: "1", Req: getTestReq(), At: now}))
+	require.NoError(t, store.Add(&ScheduledReq{ID: "2", Req: getTestReq(), At: now.Add(time.Millisecond)}))
+
+	// 第一个请求发送后结束，第二个请求放回Store
+	s.release(c)
+	assert.Equal(t, 1, sent)
+	due, err := store.Due(now.Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(due))
+	assert.Equal(t, "2", due[0].ID)
+
+	// 交给Async失败时放回Store
+	s.Async = NewAsyncSender(ctx, AsyncOptions{})
+	s.Async.Close(context.Background())
+	require.NoError(t, store.Add(due[0]))
+	s.release(context.Background())
+	due, err = store.Due(now.Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(due))
+	assert.Equal(t, "2", due[0].ID)
+	assert.Equal(t, getTestReq(), due[0].Req)
+}
@@ -2,12 +2,16 @@ package sms
 
 import (
 	"errors"
+	"github.com/uber-go/zap"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 )
 
+// Selector 为一次请求选择Sender，通常根据req.Category选择。
+// 返回的Sender可以由多个Sender组合而成，比如按号码分组交给不同的Sender发送
 type Selector interface {
-	Select(category string) (sender Sender, errCode int32, err error)
+	Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error)
 }
 
 type RandomSelector struct {
@@ -15,13 +19,17 @@ type RandomSelector struct {
 	sync.RWMutex
 }
 
-func (rs *RandomSelector) Select(category string) (sender Sender, errCode int32, err error) {
+func (rs *RandomSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
 	rs.RLock()
-	if senders, ok := rs.senders[category]; ok && len(senders) > 0 {
+	if senders, ok := rs.senders[req.Category]; ok && len(senders) > 0 {
 		sender = senders[rand.Intn(len(senders))]
 	} else {
 		errCode = CodeNoSender
-		err = errors.New("no sender under " + category)
+		err = errors.New("no sender under " + req.Category)
 	}
 	rs.RUnlock()
 	return
@@ -40,3 +48,249 @@ func (rs *RandomSelector) AddSender(category string, s Sender) {
 	}
 	rs.Unlock()
 }
+
+// WeightedSelector 按权重随机选择Sender
+type WeightedSelector struct {
+	senders map[string][]weightedSender
+	sync.RWMutex
+}
+
+type weightedSender struct {
+	sender Sender
+	weight int
+}
+
+// AddSender 添加权重为weight的Sender，weight不大于0的Sender不会被选中
+func (ws *WeightedSelector) AddSender(category string, s Sender, weight int) {
+	ws.Lock()
+	if ws.senders == nil {
+		ws.senders = make(map[string][]weightedSender)
+	}
+	ws.senders[category] = append(ws.senders[category], weightedSender{sender: s, weight: weight})
+	ws.Unlock()
+}
+
+// SetWeight 修改category下名字为name(见SenderName)的Sender的权重，没有找到时返回false
+func (ws *WeightedSelector) SetWeight(category string, name string, weight int) (found bool) {
+	ws.Lock()
+	senders := ws.senders[category]
+	for i := range senders {
+		if SenderName(senders[i].sender) == name {
+			senders[i].weight = weight
+			found = true
+		}
+	}
+	ws.Unlock()
+	return
+}
+
+func (ws *WeightedSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
+	ws.RLock()
+	senders := ws.senders[req.Category]
+	total := 0
+	for _, s := range senders {
+		if s.weight > 0 {
+			total += s.weight
+		}
+	}
+	if total > 0 {
+		n := rand.Intn(total)
+		for _, s := range senders {
+			if s.weight <= 0 {
+				continue
+			}
+			if n < s.weight {
+				sender = s.sender
+				break
+			}
+			n -= s.weight
+		}
+	}
+	ws.RUnlock()
+	if sender == nil {
+		errCode = CodeNoSender
+		err = errors.New("no sender under " + req.Category)
+	}
+	return
+}
+
+// RoundRobinSelector 轮流选择Sender
+type RoundRobinSelector struct {
+	senders map[string]*roundRobinSenders
+	sync.RWMutex
+}
+
+type roundRobinSenders struct {
+	next    uint64 // 放在第一个字段保证32位平台上atomic操作时64位对齐
+	senders []Sender
+}
+
+func (rr *RoundRobinSelector) AddSender(category string, s Sender) {
+	rr.Lock()
+	if rr.senders == nil {
+		rr.senders = make(map[string]*roundRobinSenders)
+	}
+	if rs, ok := rr.senders[category]; ok {
+		rs.senders = append(rs.senders, s)
+	} else {
+		rr.senders[category] = &roundRobinSenders{senders: []Sender{s}}
+	}
+	rr.Unlock()
+}
+
+func (rr *RoundRobinSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
+	rr.RLock()
+	if rs, ok := rr.senders[req.Category]; ok && len(rs.senders) > 0 {
+		n := atomic.AddUint64(&rs.next, 1) - 1
+		sender = rs.senders[n%uint64(len(rs.senders))]
+	} else {
+		errCode = CodeNoSender
+		err = errors.New("no sender under " + req.Category)
+	}
+	rr.RUnlock()
+	return
+}
+
+// FailoverSelector 按层级发送，前一层发送失败的号码交给下一层重试。
+// 同一层中有多个Sender时随机选择一个
+type FailoverSelector struct {
+	tiers map[string][][]Sender
+	sync.RWMutex
+}
+
+// AddSender 将s加到category下的第tier层，tier越小越先尝试
+func (fs *FailoverSelector) AddSender(category string, tier int, s Sender) {
+	fs.Lock()
+	if fs.tiers == nil {
+		fs.tiers = make(map[string][][]Sender)
+	}
+	tiers := fs.tiers[category]
+	for len(tiers) <= tier {
+		tiers = append(tiers, nil)
+	}
+	tiers[tier] = append(tiers[tier], s)
+	fs.tiers[category] = tiers
+	fs.Unlock()
+}
+
+func (fs *FailoverSelector) Select(ctx *Context, req *SMSReq) (sender Sender, errCode int32, err error) {
+	if err = ctx.Err(); err != nil {
+		errCode = CodeTimeout
+		return
+	}
+	var chain []Sender
+	fs.RLock()
+	for _, senders := range fs.tiers[req.Category] {
+		if len(senders) > 0 {
+			chain = append(chain, senders[rand.Intn(len(senders))])
+		}
+	}
+	fs.RUnlock()
+	if len(chain) == 0 {
+		errCode = CodeNoSender
+		err = errors.New("no sender under " + req.Category)
+		return
+	}
+	sender = failoverSender(chain)
+	return
+}
+
+// failoverSender 依次使用每个Sender发送上一个Sender失败的号码
+type failoverSender []Sender
+
+func (chain failoverSender) Name() string {
+	return "failover"
+}
+
+func (chain failoverSender) dispatch() {}
+
+func (chain failoverSender) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
+	var (
+		pending   = req.PhoneNumbers
+		failed    []FailReq
+		succeeded int
+		last      SMSResp
+	)
+	for i, s := range chain {
+		if len(pending) == 0 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			// 被取消时还没有发送成功的号码按超时失败
+			failed = appendFailed(nil, pending, err)
+			last = SMSResp{Code: CodeTimeout, Message: err.Error()}
+			break
+		}
+
+		subReq := *req
+		subReq.PhoneNumbers = pending
+		last = SMSResp{ID: resp.ID}
+		s.Send(ctx, &subReq, &last)
+
+		name := SenderName(s)
+		completeResults(&subReq, &last, name)
+		mergeResults(resp, &last)
+
+		failed = failedNumbers(&subReq, &last)
+		succeeded += len(pending) - len(failed)
+		if len(failed) > 0 {
+			ctx.Logger.Warn(
+				"sender failed, try next tier",
+				zap.String("id", resp.ID),
+				zap.String("sender", name),
+				zap.Int("tier", i),
+				zap.Int("failed", len(failed)),
+				zap.String("message", last.Message),
+			)
+		}
+		pending = pending[:0:0]
+		for _, f := range failed {
+			pending = append(pending, f.PhoneNumber)
+		}
+	}
+
+	resp.Fail = append(resp.Fail, failed...)
+	setMergedCode(resp, succeeded, len(failed), &last)
+}
+
+// setMergedCode 根据成功和失败的号码数设置合并后的resp.Code，全部失败时使用last中的错误
+func setMergedCode(resp *SMSResp, succeeded, failed int, last *SMSResp) {
+	switch {
+	case failed == 0:
+		resp.Code = CodeSuccess
+	case succeeded > 0:
+		resp.Code = CodeSuccessPart
+	default:
+		resp.Code = last.Code
+		resp.Message = last.Message
+	}
+}
+
+// failedNumbers 返回req中发送失败的号码。
+// resp.Code不是成功或部分成功时，所有号码都算作失败
+func failedNumbers(req *SMSReq, resp *SMSResp) []FailReq {
+	if resp.Code == CodeSuccess || resp.Code == CodeSuccessPart {
+		return resp.Fail
+	}
+	fails := make(map[string]FailReq, len(resp.Fail))
+	for _, f := range resp.Fail {
+		fails[f.PhoneNumber] = f
+	}
+	failed := make([]FailReq, 0, len(req.PhoneNumbers))
+	for _, pn := range req.PhoneNumbers {
+		f, ok := fails[pn]
+		if !ok {
+			f = failReqOfCode(pn, resp.Code, resp.Message)
+		}
+		failed = append(failed, f)
+	}
+	return failed
+}
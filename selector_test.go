@@ -0,0 +1,142 @@
+package sms
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+func TestFailoverSelector(t *testing.T) {
+	req := getTestReq()
+	selector := &FailoverSelector{}
+	// 第一层只能发送第一个号码
+	selector.AddSender(req.Category, 0, NameSender("first", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		for _, pn := range req.PhoneNumbers[1:] {
+			resp.Fail = append(resp.Fail, FailReq{PhoneNumber: pn, FailReason: "first failed"})
+		}
+		resp.Code = CodeSuccessPart
+	})))
+	// 第二层整体失败
+	selector.AddSender(req.Category, 1, NameSender("second", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		assert.Equal(t, []string{"1000001", "1000002"}, req.PhoneNumbers)
+		resp.Code = CodeOther
+		resp.Message = "second down"
+	})))
+	// 第三层只能发送最后一个号码
+	selector.AddSender(req.Category, 2, NameSender("third", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		assert.Equal(t, []string{"1000001", "1000002"}, req.PhoneNumbers)
+		resp.Fail = append(resp.Fail, FailReq{PhoneNumber: req.PhoneNumbers[0], FailReason: "third failed"})
+		resp.Code = CodeSuccessPart
+	})))
+
+	resp := Send(&Context{Selector: selector}, req)
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	require.Equal(t, 1, len(resp.Fail))
+	assert.Equal(t, FailReq{PhoneNumber: "1000001", FailReason: "third failed"}, resp.Fail[0])
+	sort.Slice(resp.Results, func(i, j int) bool { return resp.Results[i].PhoneNumber < resp.Results[j].PhoneNumber })
+	assert.Equal(t, []Result{
+		{PhoneNumber: "1000000", Status: StatusSent, Sender: "first", Code: CodeSuccess},
+		{PhoneNumber: "1000001", Status: StatusFailed, Sender: "third", Code: CodeOther},
+		{PhoneNumber: "1000002", Status: StatusSent, Sender: "third", Code: CodeSuccess},
+	}, resp.Results)
+}
+
+func TestFailoverSelector_AllFailed(t *testing.T) {
+	req := getTestReq()
+	selector := &FailoverSelector{}
+	selector.AddSender(req.Category, 0, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeOther
+		resp.Message = "down"
+	}))
+
+	resp := Send(&Context{Selector: selector}, req)
+	assert.Equal(t, CodeOther, resp.Code)
+	assert.Equal(t, "down", resp.Message)
+	assert.Equal(t, 3, len(resp.Fail))
+
+	_, errCode, err := selector.Select(&Context{}, &SMSReq{Category: "unknown"})
+	assert.Error(t, err)
+	assert.Equal(t, CodeNoSender, errCode)
+}
+
+func TestWeightedSelector(t *testing.T) {
+	selector := &WeightedSelector{}
+	selector.AddSender("test", NameSender("a", &MockSender{}), 70)
+	selector.AddSender("test", NameSender("b", &MockSender{}), 30)
+
+	const n = 100000
+	countSelect := func() map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			s, _, err := selector.Select(&Context{}, &SMSReq{Category: "test"})
+			require.NoError(t, err)
+			counts[SenderName(s)]++
+		}
+		return counts
+	}
+
+	counts := countSelect()
+	assert.InDelta(t, 0.7, float64(counts["a"])/n, 0.01)
+	assert.InDelta(t, 0.3, float64(counts["b"])/n, 0.01)
+
+	require.True(t, selector.SetWeight("test", "b", 50))
+	require.False(t, selector.SetWeight("test", "c", 50))
+	counts = countSelect()
+	assert.InDelta(t, 70.0/120, float64(counts["a"])/n, 0.01)
+	assert.InDelta(t, 50.0/120, float64(counts["b"])/n, 0.01)
+
+	selector.SetWeight("test", "a", 0)
+	selector.SetWeight("test", "b", 0)
+	_, errCode, err := selector.Select(&Context{}, &SMSReq{Category: "test"})
+	assert.Error(t, err)
+	assert.Equal(t, CodeNoSender, errCode)
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	selector := &RoundRobinSelector{}
+	selector.AddSender("test", NameSender("a", &MockSender{}))
+	selector.AddSender("test", NameSender("b", &MockSender{}))
+	selector.AddSender("test", NameSender("c", &MockSender{}))
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		s, _, err := selector.Select(&Context{}, &SMSReq{Category: "test"})
+		require.NoError(t, err)
+		counts[SenderName(s)]++
+	}
+	assert.Equal(t, map[string]int{"a": 1000, "b": 1000, "c": 1000}, counts)
+
+	_, errCode, err := selector.Select(&Context{}, &SMSReq{Category: "unknown"})
+	assert.Error(t, err)
+	assert.Equal(t, CodeNoSender, errCode)
+}
+
+func TestFailoverSelector_Canceled(t *testing.T) {
+	req := getTestReq()
+	selector := &FailoverSelector{}
+	selector.AddSender(req.Category, 0, NameSender("first", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		t.Error("canceled request should not be sent")
+	})))
+	sender, _, err := selector.Select(&Context{}, req)
+	require.NoError(t, err)
+
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := &Context{Context: c}
+	ctx.setDefaults()
+	resp := &SMSResp{}
+	sender.Send(ctx, req, resp)
+
+	assert.Equal(t, CodeTimeout, resp.Code)
+	require.Equal(t, 3, len(resp.Fail))
+	for _, f := range resp.Fail {
+		assert.Equal(t, ReasonTimeout, f.Reason)
+	}
+	completeResults(req, resp, resultSender(sender))
+	for _, r := range resp.Results {
+		assert.Equal(t, StatusFailed, r.Status)
+		assert.Empty(t, r.Sender)
+	}
+}
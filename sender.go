@@ -1,6 +1,7 @@
 package sms
 
 import (
+	"fmt"
 	"github.com/uber-go/zap"
 )
 
@@ -14,8 +15,59 @@ func (sf SenderFunc) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
 	sf(ctx, req, resp)
 }
 
+// NamedSender 有名字的Sender，名字用于在SMSResp中记录号码是由哪个Sender处理的
+type NamedSender interface {
+	Sender
+	Name() string
+}
+
+// dispatcher 将号码分给其他Sender发送的组合Sender，每个号码的结果由实际发送的Sender补全，
+// 没有交给任何Sender的号码结果中Sender为空
+type dispatcher interface {
+	dispatch()
+}
+
+// resultSender 返回补全s的发送结果时使用的Sender名字，s是dispatcher时为空
+func resultSender(s Sender) string {
+	switch s := s.(type) {
+	case dispatcher:
+		return ""
+	case *retrySender:
+		return resultSender(s.sender)
+	case *breakerSender:
+		return resultSender(s.sender)
+	}
+	return SenderName(s)
+}
+
+type namedSender struct {
+	Sender
+	name string
+}
+
+func (ns namedSender) Name() string {
+	return ns.name
+}
+
+// NameSender 给s起一个名字
+func NameSender(name string, s Sender) NamedSender {
+	return namedSender{Sender: s, name: name}
+}
+
+// SenderName 返回s的名字，s没有实现NamedSender时返回它的类型名
+func SenderName(s Sender) string {
+	if ns, ok := s.(NamedSender); ok {
+		return ns.Name()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
 type MockSender struct{}
 
+func (ms *MockSender) Name() string {
+	return "mock"
+}
+
 func (ms *MockSender) Send(ctx *Context, req *SMSReq, resp *SMSResp) {
 	ctx.Logger.Debug(
 		"send sms",
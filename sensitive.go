@@ -0,0 +1,290 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"github.com/uber-go/zap"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+var ErrSensitiveContent = errors.New("sensitive content")
+
+// WordMatch 内容中匹配到的敏感词，Start和End是rune下标
+type WordMatch struct {
+	Word  string
+	Start int
+	End   int
+}
+
+// acNode Aho-Corasick自动机的节点
+type acNode struct {
+	next map[rune]int32
+	fail int32
+	out  []int32 // 在该节点结束的词(包括fail链上的)在words中的下标
+}
+
+// acMatcher 由一组词构建的Aho-Corasick自动机，只扫描一遍内容就能找到所有词，不区分大小写
+type acMatcher struct {
+	nodes []acNode
+	words [][]rune
+}
+
+func foldRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func newACMatcher(words []string) *acMatcher {
+	m := &acMatcher{nodes: []acNode{{}}}
+	for _, word := range words {
+		runes := foldRunes(strings.TrimSpace(word))
+		if len(runes) == 0 {
+			continue
+		}
+		cur := int32(0)
+		for _, r := range runes {
+			next, ok := m.nodes[cur].next[r]
+			if !ok {
+				if m.nodes[cur].next == nil {
+					m.nodes[cur].next = make(map[rune]int32)
+				}
+				next = int32(len(m.nodes))
+				m.nodes[cur].next[r] = next
+				m.nodes = append(m.nodes, acNode{})
+			}
+			cur = next
+		}
+		m.nodes[cur].out = append(m.nodes[cur].out, int32(len(m.words)))
+		m.words = append(m.words, runes)
+	}
+
+	// 按层次设置fail指针，并合并fail节点的输出
+	queue := make([]int32, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[cur].next {
+			fail := m.nodes[cur].fail
+			for {
+				if next, ok := m.nodes[fail].next[r]; ok {
+					m.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[m.nodes[child].fail].out...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+func (m *acMatcher) step(cur int32, r rune) int32 {
+	for {
+		if next, ok := m.nodes[cur].next[r]; ok {
+			return next
+		}
+		if cur == 0 {
+			return 0
+		}
+		cur = m.nodes[cur].fail
+	}
+}
+
+// find 返回content中的所有匹配，first为true时找到第一个就返回
+func (m *acMatcher) find(content []rune, first bool) []WordMatch {
+	var (
+		matches []WordMatch
+		cur     int32
+	)
+	for i, r := range content {
+		cur = m.step(cur, unicode.ToLower(r))
+		for _, w := range m.nodes[cur].out {
+			n := len(m.words[w])
+			matches = append(matches, WordMatch{
+				Word:  string(content[i+1-n : i+1]),
+				Start: i + 1 - n,
+				End:   i + 1,
+			})
+			if first {
+				return matches
+			}
+		}
+	}
+	return matches
+}
+
+// SensitiveWords 从Source加载的敏感词词典，Reload可以在运行时重新加载
+type SensitiveWords struct {
+	Source  RulesSource
+	matcher atomic.Value
+}
+
+func NewSensitiveWords(source RulesSource) (*SensitiveWords, error) {
+	sw := &SensitiveWords{Source: source}
+	if err := sw.Reload(); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Reload 重新加载词典，失败时继续使用原来的词典。空行和以#开头的行被忽略
+func (sw *SensitiveWords) Reload() error {
+	lines, err := sw.Source.Load()
+	if err != nil {
+		return err
+	}
+	words := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			words = append(words, line)
+		}
+	}
+	sw.matcher.Store(newACMatcher(words))
+	return nil
+}
+
+// ReloadEvery 每隔interval重新加载一次词典，直到c结束
+func (sw *SensitiveWords) ReloadEvery(c context.Context, interval time.Duration, logger zap.Logger) {
+	reloadEvery(c, interval, logger, sw.Reload)
+}
+
+// Find 返回content中的所有敏感词，重叠的词都会返回
+func (sw *SensitiveWords) Find(content string) []WordMatch {
+	m, _ := sw.matcher.Load().(*acMatcher)
+	if m == nil {
+		return nil
+	}
+	return m.find([]rune(content), false)
+}
+
+// Contains 判断content中是否有敏感词
+func (sw *SensitiveWords) Contains(content string) bool {
+	m, _ := sw.matcher.Load().(*acMatcher)
+	return m != nil && len(m.find([]rune(content), true)) > 0
+}
+
+// Mask 将content中的敏感词替换成mask
+func (sw *SensitiveWords) Mask(content string, mask rune) (string, []WordMatch) {
+	runes := []rune(content)
+	m, _ := sw.matcher.Load().(*acMatcher)
+	if m == nil {
+		return content, nil
+	}
+	matches := m.find(runes, false)
+	for _, match := range matches {
+		for i := match.Start; i < match.End; i++ {
+			runes[i] = mask
+		}
+	}
+	return string(runes), matches
+}
+
+// SensitiveAction 内容包含敏感词时的处理方式
+type SensitiveAction int
+
+const (
+	SensitiveReject SensitiveAction = iota // 所有号码发送失败
+	SensitiveMask                          // 替换成*后发送
+	SensitiveLog                           // 只记录日志
+)
+
+// SensitiveWordFilter 检查ContentFilter生成的req.Content中的敏感词，需要注册在ContentFilter之后。
+// 每个category可以设置不同的处理方式，没有设置时使用DefaultAction
+type SensitiveWordFilter struct {
+	Words         *SensitiveWords
+	DefaultAction SensitiveAction
+	Mask          rune // 为0时使用*
+
+	actions map[string]SensitiveAction
+	sync.RWMutex
+}
+
+func (sf *SensitiveWordFilter) SetAction(category string, action SensitiveAction) {
+	sf.Lock()
+	if sf.actions == nil {
+		sf.actions = make(map[string]SensitiveAction)
+	}
+	sf.actions[category] = action
+	sf.Unlock()
+}
+
+func (sf *SensitiveWordFilter) action(category string) SensitiveAction {
+	sf.RLock()
+	defer sf.RUnlock()
+	if action, ok := sf.actions[category]; ok {
+		return action
+	}
+	return sf.DefaultAction
+}
+
+func matchedWords(matches []WordMatch) []string {
+	words := make([]string, len(matches))
+	for i, m := range matches {
+		words[i] = m.Word
+	}
+	return words
+}
+
+func (sf *SensitiveWordFilter) FilterFunc() Filter {
+	return func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		if req.Content == "" {
+			return false
+		}
+		switch sf.action(req.Category) {
+		case SensitiveMask:
+			mask := sf.Mask
+			if mask == 0 {
+				mask = '*'
+			}
+			content, matches := sf.Words.Mask(req.Content, mask)
+			if len(matches) > 0 {
+				ctx.Logger.Info(
+					"mask sensitive words",
+					zap.String("id", resp.ID),
+					zap.String("words", strings.Join(matchedWords(matches), ",")),
+				)
+				req.Content = content
+			}
+		case SensitiveLog:
+			if matches := sf.Words.Find(req.Content); len(matches) > 0 {
+				ctx.Logger.Warn(
+					"sensitive words found",
+					zap.String("id", resp.ID),
+					zap.String("category", req.Category),
+					zap.String("words", strings.Join(matchedWords(matches), ",")),
+				)
+			}
+		default:
+			matches := sf.Words.Find(req.Content)
+			if len(matches) == 0 {
+				return false
+			}
+			words := strings.Join(matchedWords(matches), ",")
+			ctx.Logger.Warn("reject sensitive content", zap.String("id", resp.ID), zap.String("words", words))
+			resp.Code = CodeInvalidParam
+			resp.Message = ErrSensitiveContent.Error() + ": " + words
+			for _, pn := range req.PhoneNumbers {
+				f := NewFailReq(pn, ErrSensitiveContent)
+				f.FailReason = resp.Message
+				resp.Fail = append(resp.Fail, f)
+			}
+			req.PhoneNumbers = nil
+			return true
+		}
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package sms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSensitiveWords_Find(t *testing.T) {
+	sw, err := NewSensitiveWords(StaticRules{"he", "she", "his", "hers", "# comment", "", "代开发票", "发票"})
+	require.NoError(t, err)
+
+	matches := sw.Find("uSHErs")
+	assert.Equal(t, []WordMatch{
+		{Word: "SHE", Start: 1, End: 4},
+		{Word: "HE", Start: 2, End: 4},
+		{Word: "HErs", Start: 2, End: 6},
+	}, matches)
+
+	assert.True(t, sw.Contains("您好，代开发票请联系"))
+	assert.False(t, sw.Contains("您的验证码是1234"))
+	assert.False(t, sw.Contains("# comment"))
+
+	masked, matches := sw.Mask("您好，代开发票请联系", '*')
+	assert.Equal(t, "您好，****请联系", masked)
+	assert.Equal(t, 2, len(matches))
+}
+
+func TestSensitiveWords_BruteForce(t *testing.T) {
+	const alphabet = "abc"
+	randString := func(n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[rand.Intn(len(alphabet))]
+		}
+		return string(b)
+	}
+	words := make([]string, 20)
+	for i := range words {
+		words[i] = randString(1 + rand.Intn(4))
+	}
+	sw, err := NewSensitiveWords(StaticRules(words))
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		content := randString(30)
+		expected := make(map[WordMatch]int)
+		for _, w := range words {
+			for start := 0; start+len(w) <= len(content); start++ {
+				if strings.HasPrefix(content[start:], w) {
+					expected[WordMatch{Word: w, Start: start, End: start + len(w)}]++
+				}
+			}
+		}
+		found := make(map[WordMatch]int)
+		for _, m := range sw.Find(content) {
+			found[m]++
+		}
+		require.Equal(t, expected, found, content)
+	}
+}
+
+func TestSensitiveWordFilter(t *testing.T) {
+	f, err := ioutil.TempFile("", "words")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("赌博\n")
+	f.Close()
+
+	sw, err := NewSensitiveWords(FileRules(f.Name()))
+	require.NoError(t, err)
+	sf := &SensitiveWordFilter{Words: sw}
+	sf.SetAction("mask", SensitiveMask)
+	sf.SetAction("log", SensitiveLog)
+
+	ctx := &Context{Logger: zap.NewJSON()}
+	ctx.Logger.SetLevel(zap.ErrorLevel)
+	filter := sf.FilterFunc()
+	run := func(category, content string) (*SMSReq, *SMSResp, bool) {
+		req := getTestReq()
+		req.Category = category
+		req.Content = content
+		resp := &SMSResp{}
+		exit := filter(ctx, req, resp)
+		return req, resp, exit
+	}
+
+	req, resp, exit := run("test", "线上赌博")
+	assert.True(t, exit)
+	assert.Empty(t, req.PhoneNumbers)
+	assert.Equal(t, CodeInvalidParam, resp.Code)
+	require.Equal(t, 3, len(resp.Fail))
+	assert.Equal(t, ReasonSensitive, resp.Fail[0].Reason)
+	assert.False(t, resp.Fail[0].Retryable)
+
+	req, resp, exit = run("mask", "线上赌博")
+	assert.False(t, exit)
+	assert.Equal(t, "线上**", req.Content)
+	assert.Empty(t, resp.Fail)
+
+	req, _, exit = run("log", "线上赌博")
+	assert.False(t, exit)
+	assert.Equal(t, "线上赌博", req.Content)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("线上\n"), 0644))
+	require.NoError(t, sw.Reload())
+	req, _, _ = run("mask", "线上赌博")
+	assert.Equal(t, "**赌博", req.Content)
+}
@@ -1,6 +1,10 @@
 package sms
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/uber-go/zap"
 )
 
@@ -10,27 +14,218 @@ type SMSReq struct {
 	PhoneNumbers []string
 	Args         []string
 	Content      string
+	SendAt       time.Time     // 定时发送的时间，见Scheduler
+	Delay        time.Duration // 延迟发送的时间，SendAt不为零值时忽略
+	CallbackURL  string        // 到达最终状态时回调的地址，见Webhook
 }
 
 type SMSResp struct {
-	ID      string
-	Code    int32
-	Message string
-	Fail    []FailReq
+	ID       string
+	Code     int32
+	Message  string
+	Fail     []FailReq
+	Results  []Result  // 每个号码的结果，Send返回前会补全
+	Attempts []Attempt // 每次交给Sender发送的记录
 }
 
 type FailReq struct {
 	PhoneNumber string
-	FailReason  string
+	FailReason  string        // 可读的失败原因
+	Reason      ReasonCode    // 失败原因分类
+	Retryable   bool          // 稍后重试是否可能成功
+	RetryAfter  time.Duration // 建议多久之后重试，0表示没有建议
 }
 
+var (
+	ErrNoRoute = errors.New("no route")
+)
+
+// NewFailReq 根据err生成FailReq，包中定义的错误和context的错误会设置对应的Reason和Retryable，
+// 其他错误视为内部错误
+func NewFailReq(phoneNumber string, err error) FailReq {
+	f := FailReq{
+		PhoneNumber: phoneNumber,
+		FailReason:  err.Error(),
+	}
+	switch err {
+	case ErrExceedLimit:
+		f.Reason = ReasonRateLimited
+		f.Retryable = true
+	case ErrNoRoute:
+		f.Reason = ReasonNoSender
+	case ErrOptedOut:
+		f.Reason = ReasonOptedOut
+	case ErrBlacklisted:
+		f.Reason = ReasonBlacklisted
+	case ErrNotWhitelisted:
+		f.Reason = ReasonNotWhitelist
+	case ErrInvalidNumber:
+		f.Reason = ReasonInvalidNumber
+	case ErrDuplicate:
+		f.Reason = ReasonDuplicate
+	case ErrSensitiveContent:
+		f.Reason = ReasonSensitive
+	case ErrQuietHours:
+		f.Reason = ReasonQuietHours
+		f.Retryable = true
+	case context.DeadlineExceeded, context.Canceled:
+		f.Reason = ReasonTimeout
+		f.Retryable = true
+	default:
+		f.Reason = ReasonInternal
+		f.Retryable = true
+	}
+	return f
+}
+
+// failReqOfCode 生成Sender整体失败时(resp.Code为code)号码的FailReq
+func failReqOfCode(phoneNumber string, code int32, message string) FailReq {
+	f := FailReq{
+		PhoneNumber: phoneNumber,
+		FailReason:  message,
+		Retryable:   true,
+	}
+	switch code {
+	case CodeTimeout:
+		f.Reason = ReasonTimeout
+	case CodeNoSender:
+		f.Reason = ReasonNoSender
+	case CodeInvalidParam:
+		f.Reason = ReasonTemplateError
+		f.Retryable = false
+	default:
+		f.Reason = ReasonProviderError
+	}
+	return f
+}
+
+// Context 一次发送过程中的上下文。
+// 内嵌的context.Context用于传递取消信号和截止时间，Filter、Sender和Selector都应该在它结束后尽快返回
 type Context struct {
-	Logger   zap.Logger
-	Selector Selector
-	IDGen    IDGen
+	context.Context
+	Logger     zap.Logger
+	Selector   Selector
+	IDGen      IDGen
+	Retry      *RetryPolicy    // Sender发送失败时的重试策略，为空时不重试
+	DeadLetter DeadLetterStore // 保存最终失败的请求，为空时不保存
+	Messages   MessageStore    // 保存每个请求的状态，为空时不保存
+	Webhook    *Webhook        // 请求到达最终状态时回调SMSReq.CallbackURL，为空时不回调
+	Pipeline   *Pipeline       // 为空时使用DefaultPipeline中的过滤器和模板，Logger等为空时使用Pipeline中的
+
+	rewritten map[string][]string // 过滤器改写后的号码到请求中原来的号码，见rewriteNumber
+}
+
+// rewriteNumber 记录过滤器把请求中的号码orig改写成了pn，发送结束时Results和Fail中的pn会换回orig
+func (ctx *Context) rewriteNumber(orig, pn string) {
+	if ctx.rewritten == nil {
+		ctx.rewritten = make(map[string][]string)
+	}
+	for _, o := range ctx.rewritten[pn] {
+		if o == orig {
+			return
+		}
+	}
+	ctx.rewritten[pn] = append(ctx.rewritten[pn], orig)
 }
 
+// originalNumbers 返回pns在请求中原来的号码，没有被改写的号码原样返回
+func (ctx *Context) originalNumbers(pns []string) []string {
+	origs := make([]string, 0, len(pns))
+	for _, pn := range pns {
+		if o, ok := ctx.rewritten[pn]; ok {
+			origs = append(origs, o...)
+		} else {
+			origs = append(origs, pn)
+		}
+	}
+	return origs
+}
+
+// 没有设置context.Context时，以下方法的行为和context.Background()一致
+
+func (ctx *Context) Deadline() (deadline time.Time, ok bool) {
+	if ctx.Context == nil {
+		return
+	}
+	return ctx.Context.Deadline()
+}
+
+func (ctx *Context) Done() <-chan struct{} {
+	if ctx.Context == nil {
+		return nil
+	}
+	return ctx.Context.Done()
+}
+
+func (ctx *Context) Err() error {
+	if ctx.Context == nil {
+		return nil
+	}
+	return ctx.Context.Err()
+}
+
+func (ctx *Context) Value(key interface{}) interface{} {
+	if ctx.Context == nil {
+		return nil
+	}
+	return ctx.Context.Value(key)
+}
+
+// Send 使用ctx中的context.Context发送，没有设置时使用context.Background()
 func Send(ctx *Context, req *SMSReq) (resp *SMSResp) {
+	c := ctx.Context
+	if c == nil {
+		c = context.Background()
+	}
+	return SendContext(c, ctx, req)
+}
+
+// SendContext 在c的控制下发送，c被取消或超时后返回的resp.Code为CodeTimeout
+func SendContext(c context.Context, ctx *Context, req *SMSReq) (resp *SMSResp) {
+	return sendWithID(c, ctx, req, "")
+}
+
+// sendWithID 使用指定的ID发送，id为空时由ctx.IDGen生成
+func sendWithID(c context.Context, ctx *Context, req *SMSReq, id string) (resp *SMSResp) {
+	ctx.setDefaults()
+
+	// ctx可能被多个请求共用，复制一份再设置本次请求的context.Context
+	reqCtx := *ctx
+	reqCtx.Context = c
+	ctx = &reqCtx
+
+	if ctx.Pipeline == nil {
+		ctx.Pipeline = DefaultPipeline
+	}
+	return ctx.Pipeline.send(ctx, req, id)
+}
+
+// setDefaults 设置没有指定的Logger、Selector等，优先使用ctx.Pipeline中的
+func (ctx *Context) setDefaults() {
+	if p := ctx.Pipeline; p != nil {
+		p.initOnce.Do(p.init)
+		if ctx.Logger == nil {
+			ctx.Logger = p.Logger
+		}
+		if ctx.Selector == nil {
+			ctx.Selector = p.Selector
+		}
+		if ctx.IDGen == nil {
+			ctx.IDGen = p.IDGen
+		}
+		if ctx.Retry == nil {
+			ctx.Retry = p.Retry
+		}
+		if ctx.DeadLetter == nil {
+			ctx.DeadLetter = p.DeadLetter
+		}
+		if ctx.Messages == nil {
+			ctx.Messages = p.Messages
+		}
+		if ctx.Webhook == nil {
+			ctx.Webhook = p.Webhook
+		}
+	}
 	if ctx.Logger == nil {
 		ctx.Logger = zap.NewJSON()
 	}
@@ -45,37 +240,14 @@ func Send(ctx *Context, req *SMSReq) (resp *SMSResp) {
 			ctx.IDGen = idGen
 		}
 	}
+}
 
-	resp = &SMSResp{
-		ID: ctx.IDGen.Next(),
-	}
-
-	filtersRWM.RLock()
-	exit := false
-	for _, f := range filters[FilterGlobal] {
-		if exit = f(ctx, req, resp); exit {
-			break
-		}
-	}
-	if !exit {
-		for _, f := range filters[req.Category] {
-			if exit = f(ctx, req, resp); exit {
-				break
-			}
-		}
-	}
-	filtersRWM.RUnlock()
-	if exit || len(req.PhoneNumbers) == 0 {
-		return
-	}
-
-	sender, errCode, err := ctx.Selector.Select(req.Category)
-	if err != nil {
-		resp.Code = errCode
-		resp.Message = err.Error()
-		return
-	}
-	sender.Send(ctx, req, resp)
-
-	return
+func setTimeout(ctx *Context, resp *SMSResp) {
+	resp.Code = CodeTimeout
+	resp.Message = ctx.Err().Error()
+	ctx.Logger.Warn(
+		"send sms timeout",
+		zap.String("id", resp.ID),
+		zap.Error(ctx.Err()),
+	)
 }
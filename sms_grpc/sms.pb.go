@@ -11,7 +11,13 @@ It is generated from these files:
 It has these top-level messages:
 	SMSReq
 	FailReq
+	Result
 	SMSResp
+	CancelReq
+	CancelResp
+	StatusReq
+	Recipient
+	StatusResp
 */
 package sms_grpc
 
@@ -40,6 +46,9 @@ type SMSReq struct {
 	TemplateID   string   `protobuf:"bytes,2,opt,name=templateID" json:"templateID,omitempty"`
 	PhoneNumbers []string `protobuf:"bytes,3,rep,name=phoneNumbers" json:"phoneNumbers,omitempty"`
 	Args         []string `protobuf:"bytes,4,rep,name=args" json:"args,omitempty"`
+	SendAtMs     int64    `protobuf:"varint,5,opt,name=sendAtMs" json:"sendAtMs,omitempty"`
+	DelayMs      int64    `protobuf:"varint,6,opt,name=delayMs" json:"delayMs,omitempty"`
+	CallbackURL  string   `protobuf:"bytes,7,opt,name=callbackURL" json:"callbackURL,omitempty"`
 }
 
 func (m *SMSReq) Reset()                    { *m = SMSReq{} }
@@ -48,8 +57,11 @@ func (*SMSReq) ProtoMessage()               {}
 func (*SMSReq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
 type FailReq struct {
-	PhoneNumber string `protobuf:"bytes,1,opt,name=phoneNumber" json:"phoneNumber,omitempty"`
-	FailReason  string `protobuf:"bytes,2,opt,name=failReason" json:"failReason,omitempty"`
+	PhoneNumber  string `protobuf:"bytes,1,opt,name=phoneNumber" json:"phoneNumber,omitempty"`
+	FailReason   string `protobuf:"bytes,2,opt,name=failReason" json:"failReason,omitempty"`
+	Reason       string `protobuf:"bytes,3,opt,name=reason" json:"reason,omitempty"`
+	Retryable    bool   `protobuf:"varint,4,opt,name=retryable" json:"retryable,omitempty"`
+	RetryAfterMs int64  `protobuf:"varint,5,opt,name=retryAfterMs" json:"retryAfterMs,omitempty"`
 }
 
 func (m *FailReq) Reset()                    { *m = FailReq{} }
@@ -57,17 +69,31 @@ func (m *FailReq) String() string            { return proto.CompactTextString(m)
 func (*FailReq) ProtoMessage()               {}
 func (*FailReq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
+type Result struct {
+	PhoneNumber string `protobuf:"bytes,1,opt,name=phoneNumber" json:"phoneNumber,omitempty"`
+	Status      int32  `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	MsgID       string `protobuf:"bytes,3,opt,name=msgID" json:"msgID,omitempty"`
+	Sender      string `protobuf:"bytes,4,opt,name=sender" json:"sender,omitempty"`
+	Code        int32  `protobuf:"varint,5,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *Result) Reset()                    { *m = Result{} }
+func (m *Result) String() string            { return proto.CompactTextString(m) }
+func (*Result) ProtoMessage()               {}
+func (*Result) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
 type SMSResp struct {
 	Code    int32      `protobuf:"varint,1,opt,name=code" json:"code,omitempty"`
 	Id      string     `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
 	Message string     `protobuf:"bytes,3,opt,name=message" json:"message,omitempty"`
 	Fail    []*FailReq `protobuf:"bytes,4,rep,name=fail" json:"fail,omitempty"`
+	Results []*Result  `protobuf:"bytes,5,rep,name=results" json:"results,omitempty"`
 }
 
 func (m *SMSResp) Reset()                    { *m = SMSResp{} }
 func (m *SMSResp) String() string            { return proto.CompactTextString(m) }
 func (*SMSResp) ProtoMessage()               {}
-func (*SMSResp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*SMSResp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
 func (m *SMSResp) GetFail() []*FailReq {
 	if m != nil {
@@ -76,10 +102,88 @@ func (m *SMSResp) GetFail() []*FailReq {
 	return nil
 }
 
+func (m *SMSResp) GetResults() []*Result {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type CancelReq struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *CancelReq) Reset()                    { *m = CancelReq{} }
+func (m *CancelReq) String() string            { return proto.CompactTextString(m) }
+func (*CancelReq) ProtoMessage()               {}
+func (*CancelReq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+type CancelResp struct {
+	Canceled bool `protobuf:"varint,1,opt,name=canceled" json:"canceled,omitempty"`
+}
+
+func (m *CancelResp) Reset()                    { *m = CancelResp{} }
+func (m *CancelResp) String() string            { return proto.CompactTextString(m) }
+func (*CancelResp) ProtoMessage()               {}
+func (*CancelResp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type StatusReq struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *StatusReq) Reset()                    { *m = StatusReq{} }
+func (m *StatusReq) String() string            { return proto.CompactTextString(m) }
+func (*StatusReq) ProtoMessage()               {}
+func (*StatusReq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type Recipient struct {
+	PhoneNumber string `protobuf:"bytes,1,opt,name=phoneNumber" json:"phoneNumber,omitempty"`
+	Status      int32  `protobuf:"varint,2,opt,name=status" json:"status,omitempty"`
+	MsgID       string `protobuf:"bytes,3,opt,name=msgID" json:"msgID,omitempty"`
+	Sender      string `protobuf:"bytes,4,opt,name=sender" json:"sender,omitempty"`
+	Code        int32  `protobuf:"varint,5,opt,name=code" json:"code,omitempty"`
+	Reason      string `protobuf:"bytes,6,opt,name=reason" json:"reason,omitempty"`
+	UpdatedAtMs int64  `protobuf:"varint,7,opt,name=updatedAtMs" json:"updatedAtMs,omitempty"`
+	Detail      string `protobuf:"bytes,8,opt,name=detail" json:"detail,omitempty"`
+}
+
+func (m *Recipient) Reset()                    { *m = Recipient{} }
+func (m *Recipient) String() string            { return proto.CompactTextString(m) }
+func (*Recipient) ProtoMessage()               {}
+func (*Recipient) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type StatusResp struct {
+	Id          string       `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Category    string       `protobuf:"bytes,2,opt,name=category" json:"category,omitempty"`
+	TemplateID  string       `protobuf:"bytes,3,opt,name=templateID" json:"templateID,omitempty"`
+	Code        int32        `protobuf:"varint,4,opt,name=code" json:"code,omitempty"`
+	Recipients  []*Recipient `protobuf:"bytes,5,rep,name=recipients" json:"recipients,omitempty"`
+	CreatedAtMs int64        `protobuf:"varint,6,opt,name=createdAtMs" json:"createdAtMs,omitempty"`
+	UpdatedAtMs int64        `protobuf:"varint,7,opt,name=updatedAtMs" json:"updatedAtMs,omitempty"`
+}
+
+func (m *StatusResp) Reset()                    { *m = StatusResp{} }
+func (m *StatusResp) String() string            { return proto.CompactTextString(m) }
+func (*StatusResp) ProtoMessage()               {}
+func (*StatusResp) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *StatusResp) GetRecipients() []*Recipient {
+	if m != nil {
+		return m.Recipients
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*SMSReq)(nil), "sms_grpc.SMSReq")
 	proto.RegisterType((*FailReq)(nil), "sms_grpc.FailReq")
+	proto.RegisterType((*Result)(nil), "sms_grpc.Result")
 	proto.RegisterType((*SMSResp)(nil), "sms_grpc.SMSResp")
+	proto.RegisterType((*CancelReq)(nil), "sms_grpc.CancelReq")
+	proto.RegisterType((*CancelResp)(nil), "sms_grpc.CancelResp")
+	proto.RegisterType((*StatusReq)(nil), "sms_grpc.StatusReq")
+	proto.RegisterType((*Recipient)(nil), "sms_grpc.Recipient")
+	proto.RegisterType((*StatusResp)(nil), "sms_grpc.StatusResp")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -94,6 +198,8 @@ const _ = grpc.SupportPackageIsVersion3
 
 type SMSSenderClient interface {
 	Send(ctx context.Context, in *SMSReq, opts ...grpc.CallOption) (*SMSResp, error)
+	Cancel(ctx context.Context, in *CancelReq, opts ...grpc.CallOption) (*CancelResp, error)
+	GetStatus(ctx context.Context, in *StatusReq, opts ...grpc.CallOption) (*StatusResp, error)
 }
 
 type sMSSenderClient struct {
@@ -113,10 +219,30 @@ func (c *sMSSenderClient) Send(ctx context.Context, in *SMSReq, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *sMSSenderClient) Cancel(ctx context.Context, in *CancelReq, opts ...grpc.CallOption) (*CancelResp, error) {
+	out := new(CancelResp)
+	err := grpc.Invoke(ctx, "/sms_grpc.SMSSender/Cancel", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sMSSenderClient) GetStatus(ctx context.Context, in *StatusReq, opts ...grpc.CallOption) (*StatusResp, error) {
+	out := new(StatusResp)
+	err := grpc.Invoke(ctx, "/sms_grpc.SMSSender/GetStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for SMSSender service
 
 type SMSSenderServer interface {
 	Send(context.Context, *SMSReq) (*SMSResp, error)
+	Cancel(context.Context, *CancelReq) (*CancelResp, error)
+	GetStatus(context.Context, *StatusReq) (*StatusResp, error)
 }
 
 func RegisterSMSSenderServer(s *grpc.Server, srv SMSSenderServer) {
@@ -141,6 +267,42 @@ func _SMSSender_Send_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SMSSender_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SMSSenderServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sms_grpc.SMSSender/Cancel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SMSSenderServer).Cancel(ctx, req.(*CancelReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SMSSender_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SMSSenderServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sms_grpc.SMSSender/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SMSSenderServer).GetStatus(ctx, req.(*StatusReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _SMSSender_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "sms_grpc.SMSSender",
 	HandlerType: (*SMSSenderServer)(nil),
@@ -149,6 +311,14 @@ var _SMSSender_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Send",
 			Handler:    _SMSSender_Send_Handler,
 		},
+		{
+			MethodName: "Cancel",
+			Handler:    _SMSSender_Cancel_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _SMSSender_GetStatus_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
@@ -157,22 +327,42 @@ var _SMSSender_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("sms.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 261 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x5c, 0x91, 0x41, 0x4b, 0xc3, 0x40,
-	0x10, 0x85, 0x6d, 0x1b, 0x9b, 0x66, 0x2a, 0x62, 0xe7, 0xb4, 0xf4, 0x20, 0x61, 0x41, 0xf0, 0x62,
-	0x0e, 0xf5, 0xe6, 0x59, 0x04, 0x11, 0x3d, 0x24, 0x3f, 0x40, 0xb6, 0xc9, 0x1a, 0x0b, 0x4d, 0x76,
-	0xdd, 0x89, 0x07, 0xc1, 0x1f, 0xef, 0xee, 0x98, 0xd8, 0xd8, 0xdb, 0x9b, 0x37, 0x2f, 0x33, 0x5f,
-	0x66, 0x21, 0xa1, 0x86, 0x32, 0xeb, 0x4c, 0x67, 0x70, 0xe1, 0xe5, 0x6b, 0xed, 0x6c, 0x29, 0xbf,
-	0x61, 0x5e, 0x3c, 0x17, 0xb9, 0xfe, 0xc0, 0x35, 0x2c, 0x4a, 0xd5, 0xe9, 0xda, 0xb8, 0x2f, 0x31,
-	0x49, 0x27, 0xd7, 0x49, 0xfe, 0x57, 0xe3, 0x25, 0x40, 0xa7, 0x1b, 0xbb, 0xf7, 0xf5, 0xe3, 0xbd,
-	0x98, 0x72, 0x77, 0xe4, 0xa0, 0x84, 0x33, 0xfb, 0x6e, 0x5a, 0xfd, 0xf2, 0xd9, 0x6c, 0xb5, 0x23,
-	0x31, 0x4b, 0x67, 0x3e, 0xf1, 0xcf, 0x43, 0x84, 0x48, 0xb9, 0x9a, 0x44, 0xc4, 0x3d, 0xd6, 0xf2,
-	0x09, 0xe2, 0x07, 0xb5, 0xdb, 0x87, 0xf5, 0x29, 0x2c, 0x47, 0xf1, 0x9e, 0x60, 0x6c, 0x05, 0x88,
-	0x37, 0x0e, 0x2b, 0x32, 0xed, 0x00, 0x71, 0x70, 0x64, 0x0b, 0x31, 0xff, 0x0a, 0xd9, 0xb0, 0xab,
-	0x34, 0x95, 0xe6, 0x29, 0xa7, 0x39, 0x6b, 0x3c, 0x87, 0xe9, 0xae, 0xea, 0x3f, 0xf3, 0x0a, 0x05,
-	0xc4, 0x8d, 0x26, 0x52, 0xb5, 0xf6, 0xb8, 0xc1, 0x1c, 0x4a, 0xbc, 0x82, 0x28, 0x8c, 0x65, 0xd2,
-	0xe5, 0x66, 0x95, 0x0d, 0xc7, 0xca, 0x7a, 0xd6, 0x9c, 0xdb, 0x9b, 0x3b, 0x48, 0xfc, 0xbe, 0x42,
-	0xb7, 0x95, 0x87, 0xbb, 0x81, 0x28, 0x28, 0xbc, 0x38, 0xa4, 0x7f, 0xef, 0xba, 0x5e, 0x1d, 0x39,
-	0x64, 0xe5, 0xc9, 0x76, 0xce, 0xef, 0x70, 0xfb, 0x13, 0x00, 0x00, 0xff, 0xff, 0x6e, 0x9f, 0x02,
-	0x4c, 0x94, 0x01, 0x00, 0x00,
+	// 580 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xc4, 0x54, 0xcd, 0x8a, 0xd4, 0x40,
+	0x10, 0xb6, 0xe7, 0x27, 0x33, 0xa9, 0x11, 0x71, 0xdb, 0x65, 0x09, 0xa3, 0x48, 0x08, 0x08, 0x83,
+	0xe0, 0x1e, 0x76, 0x11, 0xbc, 0x2e, 0x2e, 0xca, 0x82, 0xe3, 0xa1, 0x83, 0x67, 0xe9, 0x49, 0x6a,
+	0xc7, 0xc1, 0xfc, 0xd9, 0xdd, 0x73, 0xd8, 0xbb, 0x4f, 0xe1, 0xdd, 0x93, 0xaf, 0xe3, 0xc5, 0x07,
+	0xf0, 0x3d, 0xa4, 0xab, 0x93, 0x4c, 0xcf, 0x8e, 0xa8, 0x37, 0x6f, 0xf9, 0xbe, 0xaa, 0x4a, 0xea,
+	0xab, 0xfa, 0x2a, 0x10, 0xea, 0x52, 0x9f, 0x36, 0xaa, 0x36, 0x35, 0x9f, 0xea, 0x52, 0xbf, 0x5f,
+	0xab, 0x26, 0x4b, 0xbe, 0x33, 0x08, 0xd2, 0x65, 0x2a, 0xf0, 0x13, 0x9f, 0xc3, 0x34, 0x93, 0x06,
+	0xd7, 0xb5, 0xba, 0x89, 0x58, 0xcc, 0x16, 0xa1, 0xe8, 0x31, 0x7f, 0x0c, 0x60, 0xb0, 0x6c, 0x0a,
+	0x69, 0xf0, 0xea, 0x32, 0x1a, 0x50, 0xd4, 0x63, 0x78, 0x02, 0x77, 0x9b, 0x0f, 0x75, 0x85, 0x6f,
+	0xb7, 0xe5, 0x0a, 0x95, 0x8e, 0x86, 0xf1, 0x70, 0x11, 0x8a, 0x3d, 0x8e, 0x73, 0x18, 0x49, 0xb5,
+	0xd6, 0xd1, 0x88, 0x62, 0xf4, 0x6c, 0xbf, 0xa9, 0xb1, 0xca, 0x2f, 0xcc, 0x52, 0x47, 0xe3, 0x98,
+	0x2d, 0x86, 0xa2, 0xc7, 0x3c, 0x82, 0x49, 0x8e, 0x85, 0xbc, 0x59, 0xea, 0x28, 0xa0, 0x50, 0x07,
+	0x79, 0x0c, 0xb3, 0x4c, 0x16, 0xc5, 0x4a, 0x66, 0x1f, 0xdf, 0x89, 0x37, 0xd1, 0x84, 0xda, 0xf1,
+	0xa9, 0xe4, 0x2b, 0x83, 0xc9, 0x2b, 0xb9, 0x29, 0xac, 0xae, 0x18, 0x66, 0x5e, 0x1f, 0xad, 0x34,
+	0x9f, 0xb2, 0xea, 0xae, 0x29, 0x59, 0xea, 0xba, 0xea, 0xd4, 0xed, 0x18, 0x7e, 0x02, 0x81, 0x72,
+	0xb1, 0x21, 0xc5, 0x5a, 0xc4, 0x1f, 0x41, 0xa8, 0xd0, 0xa8, 0x1b, 0xb9, 0x2a, 0x30, 0x1a, 0xc5,
+	0x6c, 0x31, 0x15, 0x3b, 0xc2, 0xce, 0x84, 0xc0, 0xc5, 0xb5, 0x41, 0xd5, 0xeb, 0xdb, 0xe3, 0x92,
+	0xcf, 0x0c, 0x02, 0x81, 0x7a, 0x5b, 0x98, 0x7f, 0x68, 0xf3, 0x04, 0x02, 0x6d, 0xa4, 0xd9, 0x6a,
+	0x6a, 0x71, 0x2c, 0x5a, 0xc4, 0x8f, 0x61, 0x5c, 0xea, 0xf5, 0xd5, 0x65, 0xdb, 0x9d, 0x03, 0x94,
+	0x8d, 0x55, 0x8e, 0x8a, 0x3a, 0x0b, 0x45, 0x8b, 0xec, 0x1a, 0xb2, 0x3a, 0x47, 0x6a, 0x67, 0x2c,
+	0xe8, 0x39, 0xf9, 0xc2, 0x60, 0x42, 0x2e, 0xd0, 0x4d, 0x1f, 0x67, 0xbb, 0x38, 0xbf, 0x07, 0x83,
+	0x4d, 0xde, 0x0e, 0x66, 0xb0, 0xc9, 0xed, 0x6a, 0x4a, 0xd4, 0x5a, 0xae, 0xb1, 0xfd, 0x66, 0x07,
+	0xf9, 0x13, 0x18, 0xd9, 0xc1, 0xd1, 0x92, 0x67, 0x67, 0x47, 0xa7, 0x9d, 0xd1, 0x4e, 0xdb, 0x6d,
+	0x08, 0x0a, 0xf3, 0xa7, 0x30, 0x51, 0x24, 0xdb, 0x8e, 0xc5, 0x66, 0xde, 0xdf, 0x65, 0xba, 0x79,
+	0x88, 0x2e, 0x21, 0x79, 0x08, 0xe1, 0x4b, 0x59, 0x65, 0x48, 0xcb, 0x74, 0x9d, 0xb0, 0xae, 0x93,
+	0x64, 0x01, 0xd0, 0x05, 0x75, 0xe3, 0x2c, 0x6c, 0x11, 0xba, 0x9c, 0xa9, 0xe8, 0xb1, 0x7d, 0x4d,
+	0x4a, 0xf3, 0xfa, 0xdd, 0x6b, 0x7e, 0x30, 0x08, 0x05, 0x66, 0x9b, 0x66, 0x83, 0xd5, 0x7f, 0x5d,
+	0x85, 0xe7, 0xb5, 0x60, 0xcf, 0x6b, 0x31, 0xcc, 0xb6, 0x4d, 0x2e, 0x0d, 0xba, 0x63, 0x99, 0x90,
+	0x99, 0x7c, 0xca, 0x56, 0xe6, 0x68, 0xec, 0xf0, 0xa7, 0xae, 0xd2, 0xa1, 0xe4, 0x27, 0x03, 0xe8,
+	0x94, 0xeb, 0xe6, 0xb6, 0xf4, 0xbd, 0xb3, 0x1f, 0xfc, 0xf1, 0xec, 0x87, 0x07, 0x67, 0xdf, 0x09,
+	0x18, 0x79, 0x02, 0xce, 0x01, 0x54, 0x37, 0xc9, 0x6e, 0xbb, 0x0f, 0xfc, 0xed, 0xb6, 0x31, 0xe1,
+	0xa5, 0xd1, 0x45, 0x2b, 0xec, 0xd5, 0xb9, 0x7b, 0xf7, 0xa9, 0xbf, 0xeb, 0x3f, 0xfb, 0xc6, 0x20,
+	0x4c, 0x97, 0x69, 0xea, 0x66, 0xfb, 0x0c, 0x46, 0xf6, 0x89, 0x7b, 0xc6, 0x72, 0xff, 0xb9, 0xf9,
+	0xd1, 0x2d, 0x46, 0x37, 0xc9, 0x1d, 0xfe, 0x1c, 0x02, 0xe7, 0x23, 0xee, 0xf5, 0xda, 0xdb, 0x6e,
+	0x7e, 0x7c, 0x48, 0x52, 0xd9, 0x0b, 0x08, 0x5f, 0xa3, 0x71, 0xd3, 0xf5, 0x2b, 0x7b, 0xa7, 0xcd,
+	0x8f, 0x0f, 0x49, 0x5b, 0xb9, 0x0a, 0xe8, 0x4f, 0x7c, 0xfe, 0x6b, 0x00, 0x06, 0x99, 0xc6, 0x5e,
+	0x96, 0x05, 0x00, 0x00,
 }
@@ -4,19 +4,23 @@ import (
 	"github.com/uber-go/zap"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"net"
 	"github.com/zhangyuchen0411/sms"
 	"sync"
+	"time"
 )
 
 type Options struct {
-	Address string
+	Address       string
+	ScheduleStore sms.ScheduleStore // 不为空时支持定时发送和Cancel
 }
 
 type SMSServer struct {
-	opt     Options
-	ctx     *sms.Context
-	reqPool *sync.Pool
+	opt       Options
+	ctx       *sms.Context
+	scheduler *sms.Scheduler
+	reqPool   *sync.Pool
 }
 
 func RunSMSServer(ctx *sms.Context, opt Options) error {
@@ -48,12 +52,18 @@ func RunSMSServer(ctx *sms.Context, opt Options) error {
 		return err
 	}
 
-	s := grpc.NewServer()
-	RegisterSMSSenderServer(s, &SMSServer{
+	server := &SMSServer{
 		opt:     opt,
 		ctx:     ctx,
 		reqPool: reqPool,
-	})
+	}
+	if opt.ScheduleStore != nil {
+		server.scheduler = sms.NewScheduler(ctx, opt.ScheduleStore)
+		go server.scheduler.Run(context.Background())
+	}
+
+	s := grpc.NewServer()
+	RegisterSMSSenderServer(s, server)
 
 	return s.Serve(lis)
 }
@@ -64,16 +74,40 @@ func (s *SMSServer) Send(ctx context.Context, req *SMSReq) (resp *SMSResp, err e
 	r.TemplateID = req.TemplateID
 	r.PhoneNumbers = req.PhoneNumbers
 	r.Args = req.Args
+	r.SendAt = time.Time{}
+	if req.SendAtMs > 0 {
+		r.SendAt = time.Unix(0, req.SendAtMs*int64(time.Millisecond))
+	}
+	r.Delay = time.Duration(req.DelayMs) * time.Millisecond
+	r.CallbackURL = req.CallbackURL
 
-	res := sms.Send(s.ctx, r)
+	var res *sms.SMSResp
+	if s.scheduler != nil {
+		res = s.scheduler.Send(ctx, r)
+	} else {
+		res = sms.SendContext(ctx, s.ctx, r)
+	}
 
 	s.reqPool.Put(r)
 
 	fail := make([]*FailReq, len(res.Fail))
 	for i, f := range res.Fail {
 		fail[i] = &FailReq{
-			PhoneNumber: f.PhoneNumber,
-			FailReason:  f.FailReason,
+			PhoneNumber:  f.PhoneNumber,
+			FailReason:   f.FailReason,
+			Reason:       string(f.Reason),
+			Retryable:    f.Retryable,
+			RetryAfterMs: int64(f.RetryAfter / time.Millisecond),
+		}
+	}
+	results := make([]*Result, len(res.Results))
+	for i, r := range res.Results {
+		results[i] = &Result{
+			PhoneNumber: r.PhoneNumber,
+			Status:      r.Status,
+			MsgID:       r.MsgID,
+			Sender:      r.Sender,
+			Code:        r.Code,
 		}
 	}
 	resp = &SMSResp{
@@ -81,9 +115,64 @@ func (s *SMSServer) Send(ctx context.Context, req *SMSReq) (resp *SMSResp, err e
 		Id:      res.ID,
 		Message: res.Message,
 		Fail:    fail,
+		Results: results,
 	}
 
 	s.ctx.Logger.Info("send result", zap.Object("req", req), zap.Object("resp", resp))
 
 	return
 }
+
+func (s *SMSServer) Cancel(ctx context.Context, req *CancelReq) (*CancelResp, error) {
+	if s.scheduler == nil {
+		return &CancelResp{}, nil
+	}
+	canceled, err := s.scheduler.Cancel(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &CancelResp{Canceled: canceled}, nil
+}
+
+func (s *SMSServer) GetStatus(ctx context.Context, req *StatusReq) (*StatusResp, error) {
+	m, err := s.ctx.Query(req.Id)
+	if err == sms.ErrNotFound {
+		return nil, grpc.Errorf(codes.NotFound, "message %s: %s", req.Id, err)
+	}
+	if err == sms.ErrNoMessageStore {
+		return nil, grpc.Errorf(codes.Unimplemented, "%s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]*Recipient, len(m.Recipients))
+	for i, r := range m.Recipients {
+		recipients[i] = &Recipient{
+			PhoneNumber: r.PhoneNumber,
+			Status:      r.Status,
+			MsgID:       r.MsgID,
+			Sender:      r.Sender,
+			Code:        r.Code,
+			Reason:      string(r.Reason),
+			UpdatedAtMs: unixMilli(r.UpdatedAt),
+			Detail:      r.Detail,
+		}
+	}
+	return &StatusResp{
+		Id:          m.ID,
+		Category:    m.Category,
+		TemplateID:  m.TemplateID,
+		Code:        m.Code,
+		Recipients:  recipients,
+		CreatedAtMs: unixMilli(m.CreatedAt),
+		UpdatedAtMs: unixMilli(m.UpdatedAt),
+	}, nil
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -1,10 +1,12 @@
 package sms
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/zap"
 	"testing"
+	"time"
 )
 
 func getTestReq() *SMSReq {
@@ -62,3 +64,56 @@ func TestSend_Sender(t *testing.T) {
 	assert.Equal(t, CodeSuccess, resp.Code)
 	assert.Empty(t, resp.Fail)
 }
+
+func TestSendContext_Timeout(t *testing.T) {
+	req := getTestReq()
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		<-ctx.Done()
+	}))
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	resp := SendContext(c, &Context{
+		Selector: selector,
+	}, req)
+	require.NotEmpty(t, resp)
+	assert.Equal(t, CodeTimeout, resp.Code)
+	assert.Equal(t, context.DeadlineExceeded.Error(), resp.Message)
+}
+
+func TestSend_Results(t *testing.T) {
+	req := getTestReq()
+	pipeline := NewPipeline()
+	pipeline.RegisterFilter(req.Category, func(ctx *Context, req *SMSReq, resp *SMSResp) (exit bool) {
+		resp.Fail = append(resp.Fail, FailReq{
+			PhoneNumber: req.PhoneNumbers[0],
+			FailReason:  "filtered",
+		})
+		req.PhoneNumbers = req.PhoneNumbers[1:]
+		return
+	})
+	selector := &RandomSelector{}
+	selector.AddSender(req.Category, NameSender("test", SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Results = append(resp.Results, Result{
+			PhoneNumber: req.PhoneNumbers[0],
+			Status:      StatusSent,
+			MsgID:       "msg-1",
+			Code:        CodeSuccess,
+		})
+		resp.Fail = append(resp.Fail, FailReq{
+			PhoneNumber: req.PhoneNumbers[1],
+			FailReason:  "rejected",
+		})
+		resp.Code = CodeSuccessPart
+	})))
+
+	resp := Send(&Context{Selector: selector, Pipeline: pipeline}, req)
+	assert.Equal(t, CodeSuccessPart, resp.Code)
+	assert.Equal(t, []Result{
+		{PhoneNumber: "1000001", Status: StatusSent, MsgID: "msg-1", Sender: "test", Code: CodeSuccess},
+		{PhoneNumber: "1000000", Status: StatusFailed, Code: CodeOther},
+		{PhoneNumber: "1000002", Status: StatusFailed, Sender: "test", Code: CodeOther},
+	}, resp.Results)
+}
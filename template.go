@@ -5,28 +5,14 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
-	"sync"
 )
 
-var templates = map[string]*SMSTemplate{}
-var templatesRWM sync.RWMutex
-
 func RegisterTemplate(id string, t *SMSTemplate) {
-	templatesRWM.Lock()
-	_, exist := templates[id]
-	if exist && t == nil { // 删除
-		delete(templates, id)
-	} else if t != nil { // 替换或新增
-		templates[id] = t
-	}
-	templatesRWM.Unlock()
+	DefaultPipeline.RegisterTemplate(id, t)
 }
 
 func FindTemplate(id string) *SMSTemplate {
-	templatesRWM.RLock()
-	t := templates[id]
-	templatesRWM.RUnlock()
-	return t
+	return DefaultPipeline.FindTemplate(id)
 }
 
 type SMSTemplate struct {
@@ -0,0 +1,312 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/uber-go/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookSignatureHeader = "X-SMS-Signature" // 值为"sha256="+SignWebhook的结果
+	WebhookTimestampHeader = "X-SMS-Timestamp" // 签名时的unix秒
+)
+
+// WebhookPayload 回调时POST的JSON内容
+type WebhookPayload struct {
+	ID         string             `json:"id"`
+	Category   string             `json:"category"`
+	TemplateID string             `json:"templateID"`
+	Code       int32              `json:"code"`
+	Recipients []WebhookRecipient `json:"recipients"`
+}
+
+type WebhookRecipient struct {
+	PhoneNumber string     `json:"phoneNumber"`
+	Status      int32      `json:"status"`
+	MsgID       string     `json:"msgID,omitempty"`
+	Code        int32      `json:"code"`
+	Reason      ReasonCode `json:"reason,omitempty"`
+	Detail      string     `json:"detail,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// Webhook 在消息到达最终状态时POST签名后的WebhookPayload到Message.CallbackURL。
+// 失败时按Retry重试，状态码为2xx时成功，除408和429外的4xx不重试。
+// 同一个消息同时只有一个回调在进行，设置了Messages时回调成功后设置Message.Notified，之后不再回调。
+// 零值可以直接使用
+type Webhook struct {
+	Secret      []byte
+	Client      *http.Client // 为空时使用超时10秒的Client
+	Retry       *RetryPolicy // 为空时不重试
+	Messages    MessageStore
+	Logger      zap.Logger // 为空时使用zap.NewJSON()
+	WaitReceipt bool       // 为true时StatusSent不算最终状态，要等到回执
+
+	once    sync.Once
+	base    context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	sending map[string]bool // 正在回调的消息ID
+	sync.Mutex
+}
+
+func NewWebhook(secret []byte, store MessageStore) *Webhook {
+	return &Webhook{
+		Secret:   secret,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Retry:    NewRetryPolicy(5, time.Second, time.Minute),
+		Messages: store,
+		Logger:   zap.NewJSON(),
+	}
+}
+
+func (wh *Webhook) init() {
+	wh.once.Do(func() {
+		if wh.Client == nil {
+			wh.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		if wh.Logger == nil {
+			wh.Logger = zap.NewJSON()
+		}
+		wh.base, wh.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// SignWebhook 返回timestamp和body的HMAC-SHA256签名，接收方用它校验请求来源
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, timestamp)
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook 校验r的签名并返回请求体，maxAge大于0时拒绝签名时间太早的请求
+func VerifyWebhook(secret []byte, r *http.Request, maxAge time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := r.Header.Get(WebhookTimestampHeader)
+	if maxAge > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)) > maxAge {
+			return nil, errors.New("invalid webhook timestamp")
+		}
+	}
+	expected := "sha256=" + SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(WebhookSignatureHeader))) {
+		return nil, errors.New("invalid webhook signature")
+	}
+	return body, nil
+}
+
+// Final 判断m中的所有号码是否都到达了最终状态，waitReceipt为true时StatusSent不算最终状态
+func (m *Message) Final(waitReceipt bool) bool {
+	for _, r := range m.Recipients {
+		switch r.Status {
+		case StatusFailed, StatusCanceled, StatusDelivered, StatusUndelivered, StatusExpired:
+		case StatusSent:
+			if waitReceipt {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Notify m到达最终状态并且设置了CallbackURL时在后台回调
+func (wh *Webhook) Notify(m *Message) {
+	if m.CallbackURL == "" || m.Notified || !m.Final(wh.WaitReceipt) {
+		return
+	}
+	wh.init()
+	if !wh.acquire(m.ID) {
+		return
+	}
+	if wh.Messages != nil {
+		stored, err := wh.Messages.Get(m.ID)
+		if err == nil && stored.Notified {
+			wh.release(m.ID)
+			return
+		}
+		if err != nil && err != ErrNotFound {
+			wh.Logger.Error("cann't get message", zap.String("id", m.ID), zap.Error(err))
+			wh.release(m.ID)
+			return
+		}
+	}
+
+	body, err := json.Marshal(payloadOf(m))
+	if err != nil {
+		wh.Logger.Error("cann't marshal webhook payload", zap.String("id", m.ID), zap.Error(err))
+		wh.release(m.ID)
+		return
+	}
+	wh.wg.Add(1)
+	go func() {
+		defer wh.wg.Done()
+		defer wh.release(m.ID)
+		if wh.deliver(m.ID, m.CallbackURL, body) {
+			wh.markNotified(m.ID)
+		}
+	}()
+}
+
+// OnDelivery 实现DeliverySubscriber，回执使消息到达最终状态时回调
+func (wh *Webhook) OnDelivery(m *Message, r *Recipient) {
+	wh.Notify(m)
+}
+
+// OnMessage 实现MessageSubscriber，一条回执更新多个号码时只回调一次
+func (wh *Webhook) OnMessage(m *Message) {
+	wh.Notify(m)
+}
+
+// acquire 标记id正在回调，已经在回调时返回false
+func (wh *Webhook) acquire(id string) bool {
+	wh.Lock()
+	defer wh.Unlock()
+	if wh.sending[id] {
+		return false
+	}
+	if wh.sending == nil {
+		wh.sending = make(map[string]bool)
+	}
+	wh.sending[id] = true
+	return true
+}
+
+func (wh *Webhook) release(id string) {
+	wh.Lock()
+	delete(wh.sending, id)
+	wh.Unlock()
+}
+
+func (wh *Webhook) markNotified(id string) {
+	if wh.Messages == nil {
+		return
+	}
+	err := wh.Messages.Update(id, func(m *Message) error {
+		m.Notified = true
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		wh.Logger.Error("cann't mark message notified", zap.String("id", id), zap.Error(err))
+	}
+}
+
+// Close 等待正在进行的回调完成，c结束时放弃剩下的重试
+func (wh *Webhook) Close(c context.Context) error {
+	wh.init()
+	done := make(chan struct{})
+	go func() {
+		wh.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-c.Done():
+		wh.cancel()
+		<-done
+		return c.Err()
+	}
+}
+
+func payloadOf(m *Message) *WebhookPayload {
+	p := &WebhookPayload{
+		ID:         m.ID,
+		Category:   m.Category,
+		TemplateID: m.TemplateID,
+		Code:       m.Code,
+		Recipients: make([]WebhookRecipient, len(m.Recipients)),
+	}
+	for i, r := range m.Recipients {
+		p.Recipients[i] = WebhookRecipient{
+			PhoneNumber: r.PhoneNumber,
+			Status:      r.Status,
+			MsgID:       r.MsgID,
+			Code:        r.Code,
+			Reason:      r.Reason,
+			Detail:      r.Detail,
+			UpdatedAt:   r.UpdatedAt,
+		}
+	}
+	return p
+}
+
+// deliver 回调直到成功或放弃，返回是否成功
+func (wh *Webhook) deliver(id, url string, body []byte) bool {
+	maxAttempts := 1
+	if wh.Retry != nil && wh.Retry.MaxAttempts > 1 {
+		maxAttempts = wh.Retry.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := wh.post(url, body)
+		if err == nil {
+			return true
+		}
+		wh.Logger.Warn(
+			"webhook failed",
+			zap.String("id", id),
+			zap.String("url", url),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		if !retry || attempt >= maxAttempts {
+			wh.Logger.Error("give up webhook", zap.String("id", id), zap.String("url", url))
+			return false
+		}
+		t := time.NewTimer(wh.Retry.Backoff(attempt))
+		select {
+		case <-t.C:
+		case <-wh.base.Done():
+			t.Stop()
+			return false
+		}
+	}
+}
+
+// post 发送一次回调，返回失败时是否需要重试
+func (wh *Webhook) post(url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(wh.base)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(wh.Secret, timestamp, body))
+
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return true, errors.New(resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return false, errors.New(resp.Status)
+	default:
+		return true, errors.New(resp.Status)
+	}
+}
@@ -0,0 +1,193 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhookServer(t *testing.T, secret []byte, failures int32) (*httptest.Server, <-chan *WebhookPayload) {
+	payloads := make(chan *WebhookPayload, 10)
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := VerifyWebhook(secret, r, time.Minute)
+		if !assert.NoError(t, err) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) <= failures {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		p := &WebhookPayload{}
+		require.NoError(t, json.Unmarshal(body, p))
+		payloads <- p
+	}))
+	return ts, payloads
+}
+
+func newTestWebhook(secret []byte, store MessageStore) *Webhook {
+	wh := NewWebhook(secret, store)
+	wh.Retry = NewRetryPolicy(3, time.Millisecond, time.Millisecond)
+	wh.Logger.SetLevel(zap.ErrorLevel)
+	return wh
+}
+
+func TestWebhook_Send(t *testing.T) {
+	secret := []byte("secret")
+	ts, payloads := newTestWebhookServer(t, secret, 1)
+	defer ts.Close()
+
+	store := &MemoryMessageStore{}
+	p := newTestPipeline(SenderFunc(func(ctx *Context, req *SMSReq, resp *SMSResp) {
+		resp.Code = CodeSuccess
+	}))
+	p.Messages = store
+	p.Webhook = newTestWebhook(secret, store)
+
+	req := getTestReq()
+	req.CallbackURL = ts.URL
+	resp := p.Send(req)
+	require.NoError(t, p.Webhook.Close(context.Background()))
+
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, resp.ID, payload.ID)
+		assert.Equal(t, CodeSuccess, payload.Code)
+		require.Equal(t, 3, len(payload.Recipients))
+		assert.Equal(t, StatusSent, payload.Recipients[0].Status)
+	default:
+		t.Fatal("webhook not called")
+	}
+
+	m, err := store.Get(resp.ID)
+	require.NoError(t, err)
+	assert.True(t, m.Notified)
+
+	// 已经回调过的消息不再回调
+	p.Webhook.Notify(m)
+	m.Notified = false
+	p.Webhook.Notify(m)
+	require.NoError(t, p.Webhook.Close(context.Background()))
+	assert.Empty(t, payloads)
+}
+
+func TestWebhook_WaitReceipt(t *testing.T) {
+	secret := []byte("secret")
+	ts, payloads := newTestWebhookServer(t, secret, 0)
+	defer ts.Close()
+
+	dr, id := newTestDeliveryReports(t)
+	wh := newTestWebhook(secret, dr.Messages)
+	wh.WaitReceipt = true
+	dr.Subscribe(wh)
+	err := dr.Messages.Update(id, func(m *Message) error {
+		m.CallbackURL = ts.URL
+		return nil
+	})
+	require.NoError(t, err)
+
+	for i, pn := range getTestReq().PhoneNumbers {
+		status := StatusDelivered
+		if i == 2 {
+			status = StatusExpired
+		}
+		require.NoError(t, dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "m-" + pn, Status: status}))
+	}
+	require.NoError(t, wh.Close(context.Background()))
+
+	require.Equal(t, 1, len(payloads))
+	payload := <-payloads
+	assert.Equal(t, id, payload.ID)
+	assert.Equal(t, StatusDelivered, payload.Recipients[0].Status)
+	assert.Equal(t, StatusExpired, payload.Recipients[2].Status)
+	assert.Equal(t, ReasonExpired, payload.Recipients[2].Reason)
+}
+
+func TestWebhook_ZeroValue(t *testing.T) {
+	secret := []byte("secret")
+	ts, payloads := newTestWebhookServer(t, secret, 0)
+	defer ts.Close()
+
+	wh := &Webhook{Secret: secret}
+	wh.Notify(&Message{
+		ID:          "1",
+		CallbackURL: ts.URL,
+		Recipients:  []Recipient{{Result: Result{PhoneNumber: "1000000", Status: StatusSent}}},
+	})
+	require.NoError(t, wh.Close(context.Background()))
+	assert.Equal(t, 1, len(payloads))
+}
+
+func TestWebhook_Failed(t *testing.T) {
+	secret := []byte("secret")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	store := &MemoryMessageStore{}
+	m := &Message{
+		ID:          "1",
+		CallbackURL: ts.URL,
+		Recipients:  []Recipient{{Result: Result{PhoneNumber: "1000000", Status: StatusSent}}},
+	}
+	require.NoError(t, store.Save(m))
+	wh := newTestWebhook(secret, store)
+	wh.Notify(m)
+	require.NoError(t, wh.Close(context.Background()))
+
+	// 回调没有成功时不标记为已回调
+	m, err := store.Get("1")
+	require.NoError(t, err)
+	assert.False(t, m.Notified)
+}
+
+func TestWebhook_ReportOnce(t *testing.T) {
+	secret := []byte("secret")
+	ts, payloads := newTestWebhookServer(t, secret, 0)
+	defer ts.Close()
+
+	dr, id := newTestDeliveryReports(t)
+	err := dr.Messages.Update(id, func(m *Message) error {
+		m.CallbackURL = ts.URL
+		for i := range m.Recipients {
+			m.Recipients[i].MsgID = "batch"
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// 一条回执更新了所有号码，只回调一次
+	wh := newTestWebhook(secret, nil)
+	wh.WaitReceipt = true
+	dr.Subscribe(wh)
+	require.NoError(t, dr.Report(&DeliveryReport{Sender: "dlr", MsgID: "batch", Status: StatusDelivered}))
+	require.NoError(t, wh.Close(context.Background()))
+
+	require.Equal(t, 1, len(payloads))
+	payload := <-payloads
+	assert.Equal(t, id, payload.ID)
+	for _, r := range payload.Recipients {
+		assert.Equal(t, StatusDelivered, r.Status)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set(WebhookTimestampHeader, "1")
+	r.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook([]byte("secret"), "1", nil))
+	_, err := VerifyWebhook([]byte("secret"), r, 0)
+	assert.NoError(t, err)
+	_, err = VerifyWebhook([]byte("other"), r, 0)
+	assert.Error(t, err)
+	_, err = VerifyWebhook([]byte("secret"), r, time.Minute)
+	assert.Error(t, err)
+}